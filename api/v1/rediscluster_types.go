@@ -0,0 +1,336 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PVCRetentionPolicy describes what happens to the PVCs of a RedisCluster's nodes when the
+// RedisCluster is deleted
+type PVCRetentionPolicy string
+
+const (
+	// PVCRetentionPolicyRetain leaves PVCs in place, along with the data stored on them
+	PVCRetentionPolicyRetain PVCRetentionPolicy = "Retain"
+	// PVCRetentionPolicyDelete deletes PVCs along with the RedisCluster
+	PVCRetentionPolicyDelete PVCRetentionPolicy = "Delete"
+)
+
+// PersistenceMode describes how a node persists its dataset to disk
+type PersistenceMode string
+
+const (
+	// PersistenceModeAOF logs every write to an append only file
+	PersistenceModeAOF PersistenceMode = "AOF"
+	// PersistenceModeRDB periodically snapshots the dataset
+	PersistenceModeRDB PersistenceMode = "RDB"
+	// PersistenceModeBoth uses both an append only file and periodic snapshots
+	PersistenceModeBoth PersistenceMode = "Both"
+	// PersistenceModeNone keeps the dataset in memory only, e.g. for a cache. Nodes get an
+	// emptyDir rather than a persistent volume, so a node whose pod is recreated starts
+	// empty and rejoins the cluster as a new node.
+	PersistenceModeNone PersistenceMode = "None"
+)
+
+// RedisPersistence configures how nodes persist their data
+type RedisPersistence struct {
+	// Mode is the persistence strategy used by every node. It can't be changed to or from
+	// None once the cluster exists.
+	// +kubebuilder:validation:Enum=AOF;RDB;Both;None
+	Mode PersistenceMode `json:"mode,omitempty"`
+
+	// SaveSchedule is when nodes snapshot the dataset in the RDB and Both modes, as pairs of
+	// seconds and changes: "900 1 300 10" snapshots once 900 seconds have passed with at
+	// least 1 change, or 300 seconds with at least 10.
+	SaveSchedule string `json:"saveSchedule,omitempty"`
+
+	// AppendFsync is how often the append only file is flushed to disk in the AOF and Both
+	// modes
+	// +kubebuilder:validation:Enum=always;everysec;no
+	AppendFsync string `json:"appendFsync,omitempty"`
+}
+
+// RedisStorageSpec is the template for the persistent volume of every node
+type RedisStorageSpec struct {
+	// Size is the size of each node's persistent volume, e.g. 10Gi
+	Size resource.Quantity `json:"size"`
+
+	// StorageClassName is the storage class used for each node's persistent volume claim.
+	// The cluster's default storage class is used when omitted.
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// RetentionPolicy determines whether node PVCs are deleted along with the cluster.
+	// PVCs are retained when omitted.
+	// +kubebuilder:validation:Enum=Retain;Delete
+	RetentionPolicy PVCRetentionPolicy `json:"retentionPolicy,omitempty"`
+}
+
+// RedisConfig configures the redis server of every node. Settings that redis allows to be
+// changed at runtime are applied with CONFIG SET, the rest by restarting nodes one at a time.
+type RedisConfig struct {
+	// MaxMemory is the memory limit of the dataset, e.g. 1Gi, beyond which keys are evicted
+	// according to MaxMemoryPolicy. 0 means no limit.
+	MaxMemory *resource.Quantity `json:"maxMemory,omitempty"`
+	// MaxMemoryPolicy determines which keys are evicted once MaxMemory is reached
+	// +kubebuilder:validation:Enum=noeviction;allkeys-lru;volatile-lru;allkeys-lfu;volatile-lfu;allkeys-random;volatile-random;volatile-ttl
+	MaxMemoryPolicy string `json:"maxMemoryPolicy,omitempty"`
+	// MaxClients is the maximum number of clients connected to a node at once
+	// +kubebuilder:validation:Minimum=1
+	MaxClients *int32 `json:"maxClients,omitempty"`
+	// Timeout is the number of seconds after which idle clients are disconnected. 0 disables
+	// the timeout.
+	// +kubebuilder:validation:Minimum=0
+	Timeout *int32 `json:"timeout,omitempty"`
+	// TCPKeepAlive is the interval in seconds between TCP keepalives sent to clients. 0
+	// disables keepalives.
+	// +kubebuilder:validation:Minimum=0
+	TCPKeepAlive *int32 `json:"tcpKeepAlive,omitempty"`
+	// ClusterNodeTimeout is the number of milliseconds a node must be unreachable for before
+	// it's considered to be failing
+	// +kubebuilder:validation:Minimum=1
+	ClusterNodeTimeout *int32 `json:"clusterNodeTimeout,omitempty"`
+	// Overrides are additional redis.conf directives, e.g. slowlog-max-len: "256". Settings
+	// managed by the controller or by other fields can't be overridden. Removing an override
+	// that redis applied at runtime leaves it in effect until nodes restart.
+	Overrides map[string]string `json:"overrides,omitempty"`
+}
+
+// RedisAuth configures the password that clients, and replicas connecting to their master,
+// must authenticate with
+type RedisAuth struct {
+	// PasswordSecret selects the key of a Secret, in the cluster's namespace, holding the
+	// password. A Secret named <cluster>-password is generated when omitted.
+	PasswordSecret *corev1.SecretKeySelector `json:"passwordSecret,omitempty"`
+
+	// RotationGracePeriod is how long nodes accept both the old and the new password when
+	// the password changes, giving clients time to switch to the new one
+	RotationGracePeriod *metav1.Duration `json:"rotationGracePeriod,omitempty"`
+}
+
+// RedisTLS configures TLS for client, replication and cluster bus traffic
+type RedisTLS struct {
+	// CertificateSecret names a Secret, in the cluster's namespace, holding the certificate
+	// (tls.crt) and key (tls.key) served by every node and the CA certificate (ca.crt) they're
+	// verified with, such as one issued by cert-manager. When omitted, the controller creates
+	// a CA, stored in the <cluster>-ca Secret, and issues each node its own certificate.
+	CertificateSecret *corev1.LocalObjectReference `json:"certificateSecret,omitempty"`
+
+	// RenewBefore is how long before its certificate expires that a node is restarted with
+	// a renewed one. A referenced certificate must have been renewed by then.
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
+}
+
+// RedisClusterSpec defines the desired state of RedisCluster
+type RedisClusterSpec struct {
+	// Masters is the number of masters, each of which serves a share of the cluster's slots.
+	// The scale subresource scales the cluster by its number of masters.
+	// +kubebuilder:validation:Minimum=3
+	Masters int32 `json:"masters"`
+
+	// ReplicasPerMaster is the number of replicas following each master
+	// +kubebuilder:validation:Minimum=0
+	ReplicasPerMaster int32 `json:"replicasPerMaster,omitempty"`
+
+	// Storage is the template for the persistent volume of every node
+	Storage RedisStorageSpec `json:"storage"`
+
+	// Image is the redis image run by every node
+	Image string `json:"image,omitempty"`
+
+	// Resources are the compute resources of each node's redis container
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Persistence configures how nodes persist their data
+	Persistence RedisPersistence `json:"persistence,omitempty"`
+
+	// Config configures the redis server of every node
+	Config RedisConfig `json:"config,omitempty"`
+
+	// Auth requires clients to authenticate with a password. Nodes accept any client when
+	// omitted.
+	Auth *RedisAuth `json:"auth,omitempty"`
+
+	// TLS requires clients, replicas and the cluster bus to connect over TLS. Nodes only
+	// accept plaintext connections when omitted. It can't be enabled or disabled once the
+	// cluster exists.
+	TLS *RedisTLS `json:"tls,omitempty"`
+
+	// RebalanceThreshold is the percentage by which the number of hash slots served by a
+	// master may differ from an even share before slots are migrated between masters to
+	// even out the distribution. Defaults to 2.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	RebalanceThreshold *int32 `json:"rebalanceThreshold,omitempty"`
+}
+
+type RedisNodeStatus struct {
+	// Name is the name of the node
+	Name string `json:"name,omitempty"`
+	// NodeID is the id the node is known by within the redis cluster
+	NodeID   string            `json:"nodeID,omitempty"`
+	IP       string            `json:"ip,omitempty"`
+	DiskSize resource.Quantity `json:"diskSize,omitempty"`
+	// Ready indicates that the node's pod is running and passing its readiness probe
+	Ready bool `json:"ready,omitempty"`
+	// StartTime is when the node's redis container last started. ACL users only live in
+	// memory, so they're reapplied to the node whenever it changes.
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// Joined indicates that the node has met the rest of the cluster
+	Joined bool `json:"joined,omitempty"`
+	// Role is whether the node is a master or a replica
+	Role RedisNodeRole `json:"role,omitempty"`
+	// MasterName is the name of the node a replica follows
+	MasterName string `json:"masterName,omitempty"`
+	// Slots is the number of hash slots served by the node
+	Slots int32 `json:"slots,omitempty"`
+	// Failed indicates that the rest of the cluster has flagged the node as failing
+	Failed bool `json:"failed,omitempty"`
+	// ConfigHash identifies the configuration last applied to the node
+	ConfigHash string `json:"configHash,omitempty"`
+	// AuthHash identifies the password the node requires
+	AuthHash string `json:"authHash,omitempty"`
+	// CertificateNotAfter is when the certificate served by the node expires
+	CertificateNotAfter *metav1.Time `json:"certificateNotAfter,omitempty"`
+}
+
+// RedisNodeRole is the role of a node within the redis cluster
+type RedisNodeRole string
+
+const (
+	// RedisNodeRoleMaster nodes serve hash slots
+	RedisNodeRoleMaster RedisNodeRole = "Master"
+	// RedisNodeRoleReplica nodes replicate a master, taking over from it should it fail
+	RedisNodeRoleReplica RedisNodeRole = "Replica"
+)
+
+// RedisClusterConditionType is a valid value for RedisClusterCondition.Type
+type RedisClusterConditionType string
+
+const (
+	// RedisClusterReady means the cluster has converged on its spec and every node is serving
+	RedisClusterReady RedisClusterConditionType = "Ready"
+	// RedisClusterProgressing means the controller is performing an action to converge on the spec
+	RedisClusterProgressing RedisClusterConditionType = "Progressing"
+	// RedisClusterDegraded means one or more nodes are not ready
+	RedisClusterDegraded RedisClusterConditionType = "Degraded"
+	// RedisClusterReconcileError means the last reconciliation failed
+	RedisClusterReconcileError RedisClusterConditionType = "ReconcileError"
+	// RedisClusterPasswordRotating means a new password is being rolled out to the nodes
+	RedisClusterPasswordRotating RedisClusterConditionType = "PasswordRotating"
+)
+
+type RedisClusterCondition struct {
+	Type   RedisClusterConditionType `json:"type"`
+	Status corev1.ConditionStatus    `json:"status"`
+	// LastTransitionTime is the last time the condition changed from one status to another
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a one word, CamelCase reason for the condition's last transition
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable description of the details of the last transition
+	Message string `json:"message,omitempty"`
+}
+
+// RedisRebalanceStatus reports the progress of a slot rebalance
+type RedisRebalanceStatus struct {
+	// SlotsMoved is the number of slots migrated since the rebalance started
+	SlotsMoved int32 `json:"slotsMoved"`
+	// SlotsRemaining is the number of slots still to be migrated
+	SlotsRemaining int32 `json:"slotsRemaining"`
+	// StartTime is when the rebalance started
+	StartTime metav1.Time `json:"startTime,omitempty"`
+}
+
+// RedisPasswordRotationPhase is the stage a password rotation has reached
+type RedisPasswordRotationPhase string
+
+const (
+	// RedisPasswordRotationAdding means the new password is being added to every node
+	// alongside the old one
+	RedisPasswordRotationAdding RedisPasswordRotationPhase = "AddingPassword"
+	// RedisPasswordRotationGracePeriod means every node accepts both passwords, and the old
+	// one is removed once the grace period has passed
+	RedisPasswordRotationGracePeriod RedisPasswordRotationPhase = "GracePeriod"
+)
+
+// RedisPasswordRotation reports the progress of rolling out a new password
+type RedisPasswordRotation struct {
+	// AuthHash identifies the password being rolled out
+	AuthHash string `json:"authHash"`
+	// Phase is the stage the rotation has reached
+	Phase RedisPasswordRotationPhase `json:"phase"`
+	// GracePeriodStartTime is when every node started accepting the new password
+	GracePeriodStartTime *metav1.Time `json:"gracePeriodStartTime,omitempty"`
+}
+
+// RedisClusterStatus defines the observed state of RedisCluster
+type RedisClusterStatus struct {
+	Nodes []RedisNodeStatus `json:"nodes,omitempty"`
+
+	// Replicas is the number of nodes that currently exist
+	Replicas int32 `json:"replicas"`
+	// Masters is the number of nodes that are currently masters
+	Masters int32 `json:"masters,omitempty"`
+	// Selector is the label selector matching the pods of the cluster's nodes
+	Selector string `json:"selector,omitempty"`
+
+	// OpenSlots is the number of slots that nodes have left migrating or importing, e.g.
+	// because a migration was interrupted
+	OpenSlots int32 `json:"openSlots,omitempty"`
+	// UncoveredSlots is the number of slots that no node serves
+	UncoveredSlots int32 `json:"uncoveredSlots,omitempty"`
+
+	// Rebalance reports the progress of the slot rebalance underway, if any
+	Rebalance *RedisRebalanceStatus `json:"rebalance,omitempty"`
+
+	// AuthHash identifies the password nodes should require, without revealing it
+	AuthHash string `json:"authHash,omitempty"`
+	// PasswordRotation reports the progress of the password rotation underway, if any
+	PasswordRotation *RedisPasswordRotation `json:"passwordRotation,omitempty"`
+
+	// ObservedGeneration is the most recent generation of the spec acted on by the controller
+	ObservedGeneration int64                   `json:"observedGeneration,omitempty"`
+	Conditions         []RedisClusterCondition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:storageversion
+// +kubebuilder:subresource:status
+// +kubebuilder:subresource:scale:specpath=.spec.masters,statuspath=.status.masters,selectorpath=.status.selector
+
+// RedisCluster is the Schema for the redisclusters API
+type RedisCluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RedisClusterSpec   `json:"spec,omitempty"`
+	Status RedisClusterStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// RedisClusterList contains a list of RedisCluster
+type RedisClusterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RedisCluster `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RedisCluster{}, &RedisClusterList{})
+}
@@ -0,0 +1,330 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"encoding/json"
+	"fmt"
+
+	dbv1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// nodesAnnotation preserves the nodes of a v1beta1 RedisCluster on its v1 representation,
+// which only describes nodes by count and a storage template
+const nodesAnnotation = "db.k8s.io/v1beta1-nodes"
+
+// convertedNodes is the part of the spec that has no v1 equivalent
+type convertedNodes struct {
+	Nodes    []RedisNodeSpec `json:"nodes,omitempty"`
+	Replicas *int32          `json:"replicas,omitempty"`
+	// ReplicasPerMaster is only recorded when the nodes don't divide into shards of
+	// ReplicasPerMaster+1, in which case v1 describes every node as a master
+	ReplicasPerMaster *int32 `json:"replicasPerMaster,omitempty"`
+}
+
+var _ conversion.Convertible = &RedisCluster{}
+
+// ConvertTo converts this RedisCluster to the hub version
+func (src *RedisCluster) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*dbv1.RedisCluster)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+
+	// v1 can't describe a number of nodes that doesn't divide into shards, which validation
+	// prevents but a write to the scale subresource doesn't, so every node is treated as a
+	// master and the actual number of replicas per master is kept in the annotation
+	converted := convertedNodes{Nodes: src.Spec.Nodes, Replicas: src.Spec.Replicas}
+	nodes := src.Spec.DesiredNodes()
+	replicasPerMaster := int(src.Spec.ReplicasPerMaster)
+	if replicasPerMaster < 0 || len(nodes)%(replicasPerMaster+1) != 0 {
+		converted.ReplicasPerMaster = &src.Spec.ReplicasPerMaster
+		replicasPerMaster = 0
+	}
+
+	data, err := json.Marshal(converted)
+	if err != nil {
+		return err
+	}
+	setAnnotation(&dst.ObjectMeta, nodesAnnotation, string(data))
+
+	dst.Spec = dbv1.RedisClusterSpec{
+		Masters:           int32(len(nodes) / (replicasPerMaster + 1)),
+		ReplicasPerMaster: int32(replicasPerMaster),
+		Storage: dbv1.RedisStorageSpec{
+			Size:             largestDiskSize(nodes),
+			StorageClassName: src.Spec.StorageClassName,
+			RetentionPolicy:  dbv1.PVCRetentionPolicy(src.Spec.PVCRetentionPolicy),
+		},
+		Image:     src.Spec.Image,
+		Resources: src.Spec.Resources,
+		Persistence: dbv1.RedisPersistence{
+			Mode:         dbv1.PersistenceMode(src.Spec.Persistence.Mode),
+			SaveSchedule: src.Spec.Persistence.SaveSchedule,
+			AppendFsync:  src.Spec.Persistence.AppendFsync,
+		},
+		Config: dbv1.RedisConfig{
+			MaxMemory:          src.Spec.Config.MaxMemory,
+			MaxMemoryPolicy:    src.Spec.Config.MaxMemoryPolicy,
+			MaxClients:         src.Spec.Config.MaxClients,
+			Timeout:            src.Spec.Config.Timeout,
+			TCPKeepAlive:       src.Spec.Config.TCPKeepAlive,
+			ClusterNodeTimeout: src.Spec.Config.ClusterNodeTimeout,
+			Overrides:          src.Spec.Config.Overrides,
+		},
+		RebalanceThreshold: src.Spec.RebalanceThreshold,
+	}
+	if src.Spec.Auth != nil {
+		dst.Spec.Auth = &dbv1.RedisAuth{
+			PasswordSecret:      src.Spec.Auth.PasswordSecret,
+			RotationGracePeriod: src.Spec.Auth.RotationGracePeriod,
+		}
+	}
+	if src.Spec.TLS != nil {
+		dst.Spec.TLS = &dbv1.RedisTLS{
+			CertificateSecret: src.Spec.TLS.CertificateSecret,
+			RenewBefore:       src.Spec.TLS.RenewBefore,
+		}
+	}
+	dst.Spec = *dst.Spec.DeepCopy()
+
+	dst.Status = dbv1.RedisClusterStatus{
+		Replicas:           src.Status.Replicas,
+		Selector:           src.Status.Selector,
+		OpenSlots:          src.Status.OpenSlots,
+		UncoveredSlots:     src.Status.UncoveredSlots,
+		AuthHash:           src.Status.AuthHash,
+		ObservedGeneration: src.Status.ObservedGeneration,
+	}
+	if rotation := src.Status.PasswordRotation; rotation != nil {
+		dst.Status.PasswordRotation = &dbv1.RedisPasswordRotation{
+			AuthHash:             rotation.AuthHash,
+			Phase:                dbv1.RedisPasswordRotationPhase(rotation.Phase),
+			GracePeriodStartTime: rotation.GracePeriodStartTime.DeepCopy(),
+		}
+	}
+	if rebalance := src.Status.Rebalance; rebalance != nil {
+		dst.Status.Rebalance = &dbv1.RedisRebalanceStatus{
+			SlotsMoved:     rebalance.SlotsMoved,
+			SlotsRemaining: rebalance.SlotsRemaining,
+			StartTime:      *rebalance.StartTime.DeepCopy(),
+		}
+	}
+	for _, node := range src.Status.Nodes {
+		dst.Status.Nodes = append(dst.Status.Nodes, dbv1.RedisNodeStatus{
+			Name:                node.Name,
+			NodeID:              node.NodeID,
+			IP:                  node.IP,
+			DiskSize:            node.DiskSize.DeepCopy(),
+			Ready:               node.Ready,
+			StartTime:           node.StartTime.DeepCopy(),
+			Joined:              node.Joined,
+			Role:                dbv1.RedisNodeRole(node.Role),
+			MasterName:          node.MasterName,
+			Slots:               node.Slots,
+			Failed:              node.Failed,
+			ConfigHash:          node.ConfigHash,
+			AuthHash:            node.AuthHash,
+			CertificateNotAfter: node.CertificateNotAfter.DeepCopy(),
+		})
+		// the v1 scale subresource reports the number of masters, which v1beta1 derives
+		// from its nodes
+		if node.Role == RedisNodeRoleMaster {
+			dst.Status.Masters++
+		}
+	}
+	for _, condition := range src.Status.Conditions {
+		dst.Status.Conditions = append(dst.Status.Conditions, dbv1.RedisClusterCondition{
+			Type:               dbv1.RedisClusterConditionType(condition.Type),
+			Status:             condition.Status,
+			LastTransitionTime: *condition.LastTransitionTime.DeepCopy(),
+			Reason:             condition.Reason,
+			Message:            condition.Message,
+		})
+	}
+
+	return nil
+}
+
+// ConvertFrom converts the hub version to this RedisCluster
+func (dst *RedisCluster) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*dbv1.RedisCluster)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+
+	nodes := convertedNodes{}
+	if data, ok := dst.Annotations[nodesAnnotation]; ok {
+		if err := json.Unmarshal([]byte(data), &nodes); err != nil {
+			return fmt.Errorf("invalid %s annotation: %v", nodesAnnotation, err)
+		}
+		delete(dst.Annotations, nodesAnnotation)
+	}
+	if len(dst.Annotations) == 0 {
+		dst.Annotations = nil
+	}
+
+	dst.Spec = RedisClusterSpec{
+		Nodes:              nodes.Nodes,
+		Replicas:           nodes.Replicas,
+		ReplicasPerMaster:  src.Spec.ReplicasPerMaster,
+		StorageClassName:   src.Spec.Storage.StorageClassName,
+		PVCRetentionPolicy: PVCRetentionPolicy(src.Spec.Storage.RetentionPolicy),
+		Image:              src.Spec.Image,
+		Resources:          src.Spec.Resources,
+		Persistence: RedisPersistence{
+			Mode:         PersistenceMode(src.Spec.Persistence.Mode),
+			SaveSchedule: src.Spec.Persistence.SaveSchedule,
+			AppendFsync:  src.Spec.Persistence.AppendFsync,
+		},
+		Config: RedisConfig{
+			MaxMemory:          src.Spec.Config.MaxMemory,
+			MaxMemoryPolicy:    src.Spec.Config.MaxMemoryPolicy,
+			MaxClients:         src.Spec.Config.MaxClients,
+			Timeout:            src.Spec.Config.Timeout,
+			TCPKeepAlive:       src.Spec.Config.TCPKeepAlive,
+			ClusterNodeTimeout: src.Spec.Config.ClusterNodeTimeout,
+			Overrides:          src.Spec.Config.Overrides,
+		},
+		RebalanceThreshold: src.Spec.RebalanceThreshold,
+	}
+	if src.Spec.Auth != nil {
+		dst.Spec.Auth = &RedisAuth{
+			PasswordSecret:      src.Spec.Auth.PasswordSecret,
+			RotationGracePeriod: src.Spec.Auth.RotationGracePeriod,
+		}
+	}
+	if src.Spec.TLS != nil {
+		dst.Spec.TLS = &RedisTLS{
+			CertificateSecret: src.Spec.TLS.CertificateSecret,
+			RenewBefore:       src.Spec.TLS.RenewBefore,
+		}
+	}
+	dst.Spec = *dst.Spec.DeepCopy()
+	dst.Spec.matchNodes(int(src.Spec.Masters*(src.Spec.ReplicasPerMaster+1)), src.Spec.Storage.Size)
+	// replicas per master that were dropped because the nodes didn't divide into shards are
+	// restored, unless v1 has since been given replicas of its own
+	if nodes.ReplicasPerMaster != nil && src.Spec.ReplicasPerMaster == 0 {
+		dst.Spec.ReplicasPerMaster = *nodes.ReplicasPerMaster
+	}
+
+	dst.Status = RedisClusterStatus{
+		Replicas:           src.Status.Replicas,
+		Selector:           src.Status.Selector,
+		OpenSlots:          src.Status.OpenSlots,
+		UncoveredSlots:     src.Status.UncoveredSlots,
+		AuthHash:           src.Status.AuthHash,
+		ObservedGeneration: src.Status.ObservedGeneration,
+	}
+	if rotation := src.Status.PasswordRotation; rotation != nil {
+		dst.Status.PasswordRotation = &RedisPasswordRotation{
+			AuthHash:             rotation.AuthHash,
+			Phase:                RedisPasswordRotationPhase(rotation.Phase),
+			GracePeriodStartTime: rotation.GracePeriodStartTime.DeepCopy(),
+		}
+	}
+	if rebalance := src.Status.Rebalance; rebalance != nil {
+		dst.Status.Rebalance = &RedisRebalanceStatus{
+			SlotsMoved:     rebalance.SlotsMoved,
+			SlotsRemaining: rebalance.SlotsRemaining,
+			StartTime:      *rebalance.StartTime.DeepCopy(),
+		}
+	}
+	for _, node := range src.Status.Nodes {
+		dst.Status.Nodes = append(dst.Status.Nodes, RedisNodeStatus{
+			Name:                node.Name,
+			NodeID:              node.NodeID,
+			IP:                  node.IP,
+			DiskSize:            node.DiskSize.DeepCopy(),
+			Ready:               node.Ready,
+			StartTime:           node.StartTime.DeepCopy(),
+			Joined:              node.Joined,
+			Role:                RedisNodeRole(node.Role),
+			MasterName:          node.MasterName,
+			Slots:               node.Slots,
+			Failed:              node.Failed,
+			ConfigHash:          node.ConfigHash,
+			AuthHash:            node.AuthHash,
+			CertificateNotAfter: node.CertificateNotAfter.DeepCopy(),
+		})
+	}
+	for _, condition := range src.Status.Conditions {
+		dst.Status.Conditions = append(dst.Status.Conditions, RedisClusterCondition{
+			Type:               RedisClusterConditionType(condition.Type),
+			Status:             condition.Status,
+			LastTransitionTime: *condition.LastTransitionTime.DeepCopy(),
+			Reason:             condition.Reason,
+			Message:            condition.Message,
+		})
+	}
+
+	return nil
+}
+
+// matchNodes brings the nodes in line with a node count and disk size that may have been
+// changed through the v1 API. Nodes keep their own disk sizes unless the size differs from
+// the largest of them, which is what v1 reports, in which case none are left smaller.
+func (s *RedisClusterSpec) matchNodes(count int, size resource.Quantity) {
+	largest := largestDiskSize(s.DesiredNodes())
+	if largest.Cmp(size) != 0 {
+		if len(s.Nodes) == 0 && s.Replicas != nil {
+			s.Nodes = s.DesiredNodes()
+		}
+		for i := range s.Nodes {
+			if s.Nodes[i].DiskSize.Cmp(size) < 0 {
+				s.Nodes[i].DiskSize = size.DeepCopy()
+			}
+		}
+	}
+
+	if len(s.DesiredNodes()) == count {
+		return
+	}
+
+	replicas := int32(count)
+	if s.Replicas != nil {
+		s.Replicas = &replicas
+		return
+	}
+	if count < len(s.Nodes) {
+		s.Nodes = s.Nodes[:count]
+		return
+	}
+
+	extended := (&RedisClusterSpec{Nodes: s.Nodes, Replicas: &replicas}).DesiredNodes()
+	for _, node := range extended[len(s.Nodes):] {
+		node.DiskSize = size.DeepCopy()
+		s.Nodes = append(s.Nodes, node)
+	}
+}
+
+// largestDiskSize returns the largest disk size of the given nodes
+func largestDiskSize(nodes []RedisNodeSpec) resource.Quantity {
+	largest := resource.Quantity{}
+	for _, node := range nodes {
+		if node.DiskSize.Cmp(largest) > 0 {
+			largest = node.DiskSize.DeepCopy()
+		}
+	}
+
+	return largest
+}
+
+func setAnnotation(meta *metav1.ObjectMeta, key, value string) {
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[key] = value
+}
@@ -0,0 +1,277 @@
+// +build unit
+
+package v1beta1
+
+import (
+	"testing"
+	"time"
+
+	dbv1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+)
+
+func requireSemanticEqual(t *testing.T, expected, actual interface{}) {
+	t.Helper()
+	require.True(t, equality.Semantic.DeepEqual(expected, actual), diff.ObjectReflectDiff(expected, actual))
+}
+
+func TestRedisClusterConversionRoundTrip(t *testing.T) {
+	storageClassName := "fast"
+	replicas := int32(4)
+	indivisible := int32(5)
+	threshold := int32(5)
+	maxMemory := resource.MustParse("200Mi")
+	now := metav1.Now()
+
+	tests := []struct {
+		name    string
+		cluster *RedisCluster
+	}{
+		{
+			name:    "empty",
+			cluster: &RedisCluster{ObjectMeta: metav1.ObjectMeta{Name: "test"}},
+		},
+		{
+			name: "named nodes",
+			cluster: &RedisCluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test",
+					Namespace:   "default",
+					Annotations: map[string]string{"a": "b"},
+					Finalizers:  []string{"db.k8s.io/teardown"},
+				},
+				Spec: RedisClusterSpec{
+					Nodes: []RedisNodeSpec{
+						{Name: "a", DiskSize: resource.MustParse("1Gi")},
+						{Name: "b", DiskSize: resource.MustParse("3Gi")},
+						{Name: "c", DiskSize: resource.MustParse("2Gi")},
+					},
+					StorageClassName:   &storageClassName,
+					PVCRetentionPolicy: PVCRetentionPolicyDelete,
+					Image:              "redis:5.0.6",
+					Resources:          DefaultResources(),
+					Persistence:        RedisPersistence{Mode: PersistenceModeAOF, AppendFsync: "always"},
+					RebalanceThreshold: &threshold,
+					Auth: &RedisAuth{
+						PasswordSecret:      &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "test-password"}, Key: "password"},
+						RotationGracePeriod: &metav1.Duration{Duration: time.Minute},
+					},
+					TLS: &RedisTLS{
+						CertificateSecret: &corev1.LocalObjectReference{Name: "test-tls"},
+						RenewBefore:       &metav1.Duration{Duration: time.Hour},
+					},
+					Config: RedisConfig{
+						MaxMemory:       &maxMemory,
+						MaxMemoryPolicy: "allkeys-lru",
+						Timeout:         &threshold,
+						Overrides:       map[string]string{"slowlog-max-len": "256"},
+					},
+				},
+				Status: RedisClusterStatus{
+					Nodes: []RedisNodeStatus{
+						{Name: "a", NodeID: "1", IP: "10.0.0.1", DiskSize: resource.MustParse("1Gi"), Ready: true, Joined: true, Role: RedisNodeRoleMaster, Slots: 16384},
+						{Name: "b", NodeID: "2", IP: "10.0.0.2", DiskSize: resource.MustParse("2Gi"), Ready: true, StartTime: &now, Role: RedisNodeRoleReplica, MasterName: "a", Failed: true, ConfigHash: "abc", AuthHash: "def", CertificateNotAfter: &now},
+					},
+					Replicas:           2,
+					Selector:           "db.k8s.io/rediscluster=test",
+					OpenSlots:          1,
+					UncoveredSlots:     2,
+					AuthHash:           "def",
+					ObservedGeneration: 3,
+					Rebalance:          &RedisRebalanceStatus{SlotsMoved: 10, SlotsRemaining: 20, StartTime: metav1.Now()},
+					PasswordRotation:   &RedisPasswordRotation{AuthHash: "ghi", Phase: RedisPasswordRotationGracePeriod, GracePeriodStartTime: &now},
+					Conditions: []RedisClusterCondition{
+						{Type: RedisClusterProgressing, Status: corev1.ConditionTrue, Reason: "AddRedisNode", LastTransitionTime: metav1.Now()},
+					},
+				},
+			},
+		},
+		{
+			name: "unnamed nodes",
+			cluster: &RedisCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test"},
+				Spec: RedisClusterSpec{
+					Nodes: []RedisNodeSpec{
+						{DiskSize: resource.MustParse("1Gi")},
+						{DiskSize: resource.MustParse("1Gi")},
+						{},
+					},
+				},
+			},
+		},
+		{
+			name: "replicas",
+			cluster: &RedisCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test"},
+				Spec: RedisClusterSpec{
+					Nodes:    []RedisNodeSpec{{Name: "a", DiskSize: resource.MustParse("1Gi")}},
+					Replicas: &replicas,
+				},
+			},
+		},
+		{
+			// a write to the scale subresource isn't validated
+			name: "replicas not divisible into shards",
+			cluster: &RedisCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test"},
+				Spec: RedisClusterSpec{
+					Nodes:             []RedisNodeSpec{{Name: "a", DiskSize: resource.MustParse("1Gi")}},
+					Replicas:          &indivisible,
+					ReplicasPerMaster: 1,
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := &dbv1.RedisCluster{}
+			require.NoError(t, tt.cluster.DeepCopy().ConvertTo(hub))
+
+			restored := &RedisCluster{}
+			require.NoError(t, restored.ConvertFrom(hub))
+			requireSemanticEqual(t, tt.cluster, restored)
+		})
+	}
+}
+
+func TestRedisClusterHubConversionRoundTrip(t *testing.T) {
+	storageClassName := "fast"
+	hub := &dbv1.RedisCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Annotations: map[string]string{"a": "b"}},
+		Spec: dbv1.RedisClusterSpec{
+			Masters:           3,
+			ReplicasPerMaster: 1,
+			Storage: dbv1.RedisStorageSpec{
+				Size:             resource.MustParse("2Gi"),
+				StorageClassName: &storageClassName,
+				RetentionPolicy:  dbv1.PVCRetentionPolicyRetain,
+			},
+			Image:       "redis:5.0.5",
+			Persistence: dbv1.RedisPersistence{Mode: dbv1.PersistenceModeRDB, SaveSchedule: "3600 1"},
+		},
+		Status: dbv1.RedisClusterStatus{
+			Nodes:    []dbv1.RedisNodeStatus{{Name: "0", IP: "10.0.0.1", DiskSize: resource.MustParse("2Gi")}},
+			Replicas: 1,
+		},
+	}
+
+	spoke := &RedisCluster{}
+	require.NoError(t, spoke.ConvertFrom(hub.DeepCopy()))
+	require.Len(t, spoke.Spec.Nodes, 6)
+	require.Equal(t, int32(1), spoke.Spec.ReplicasPerMaster)
+	for _, node := range spoke.Spec.Nodes {
+		require.Equal(t, 0, node.DiskSize.Cmp(resource.MustParse("2Gi")))
+	}
+
+	restored := &dbv1.RedisCluster{}
+	require.NoError(t, spoke.ConvertTo(restored))
+	requireSemanticEqual(t, hub.Spec, restored.Spec)
+	requireSemanticEqual(t, hub.Status, restored.Status)
+	require.Equal(t, "b", restored.Annotations["a"])
+}
+
+func TestRedisClusterConversionHubChanges(t *testing.T) {
+	cluster := &RedisCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Spec: RedisClusterSpec{
+			Nodes: []RedisNodeSpec{
+				{Name: "a", DiskSize: resource.MustParse("1Gi")},
+				{Name: "b", DiskSize: resource.MustParse("2Gi")},
+				{Name: "c", DiskSize: resource.MustParse("1Gi")},
+			},
+		},
+	}
+
+	t.Run("masters added", func(t *testing.T) {
+		hub := &dbv1.RedisCluster{}
+		require.NoError(t, cluster.DeepCopy().ConvertTo(hub))
+		hub.Spec.Masters = 4
+
+		restored := &RedisCluster{}
+		require.NoError(t, restored.ConvertFrom(hub))
+		require.Len(t, restored.Spec.Nodes, 4)
+		require.Equal(t, "a", restored.Spec.Nodes[0].Name)
+		require.Equal(t, 0, restored.Spec.Nodes[0].DiskSize.Cmp(resource.MustParse("1Gi")))
+		require.Equal(t, "3", restored.Spec.Nodes[3].Name)
+		require.Equal(t, 0, restored.Spec.Nodes[3].DiskSize.Cmp(resource.MustParse("2Gi")))
+	})
+
+	t.Run("masters removed", func(t *testing.T) {
+		hub := &dbv1.RedisCluster{}
+		require.NoError(t, cluster.DeepCopy().ConvertTo(hub))
+		hub.Spec.Masters = 2
+
+		restored := &RedisCluster{}
+		require.NoError(t, restored.ConvertFrom(hub))
+		require.Len(t, restored.Spec.Nodes, 2)
+		require.Equal(t, "b", restored.Spec.Nodes[1].Name)
+	})
+
+	t.Run("storage grown", func(t *testing.T) {
+		hub := &dbv1.RedisCluster{}
+		require.NoError(t, cluster.DeepCopy().ConvertTo(hub))
+		hub.Spec.Storage.Size = resource.MustParse("4Gi")
+
+		restored := &RedisCluster{}
+		require.NoError(t, restored.ConvertFrom(hub))
+		for _, node := range restored.Spec.Nodes {
+			require.Equal(t, 0, node.DiskSize.Cmp(resource.MustParse("4Gi")))
+		}
+	})
+
+	t.Run("nodes no longer divisible into shards", func(t *testing.T) {
+		hub := &dbv1.RedisCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "test"},
+			Spec: dbv1.RedisClusterSpec{
+				Masters:           3,
+				ReplicasPerMaster: 1,
+				Storage:           dbv1.RedisStorageSpec{Size: resource.MustParse("1Gi")},
+			},
+		}
+
+		spoke := &RedisCluster{}
+		require.NoError(t, spoke.ConvertFrom(hub))
+		spoke.Spec.Nodes = spoke.Spec.Nodes[:5]
+		spoke.Spec.ReplicasPerMaster = 1
+
+		restored := &dbv1.RedisCluster{}
+		require.NoError(t, spoke.ConvertTo(restored))
+		require.Equal(t, int32(5), restored.Spec.Masters)
+		require.Equal(t, int32(0), restored.Spec.ReplicasPerMaster)
+
+		// the replicas per master are kept, so the controller doesn't detach every replica
+		respoke := &RedisCluster{}
+		require.NoError(t, respoke.ConvertFrom(restored))
+		require.Len(t, respoke.Spec.Nodes, 5)
+		require.Equal(t, int32(1), respoke.Spec.ReplicasPerMaster)
+	})
+}
+
+func TestRedisClusterConversionScaleStatus(t *testing.T) {
+	cluster := &RedisCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Status: RedisClusterStatus{
+			Nodes: []RedisNodeStatus{
+				{Name: "a", Role: RedisNodeRoleMaster},
+				{Name: "b", Role: RedisNodeRoleReplica, MasterName: "a"},
+				{Name: "c", Role: RedisNodeRoleMaster},
+				{Name: "d"},
+			},
+			Replicas: 4,
+			Selector: "db.k8s.io/rediscluster=test",
+		},
+	}
+
+	// the v1 scale subresource counts masters rather than nodes
+	hub := &dbv1.RedisCluster{}
+	require.NoError(t, cluster.ConvertTo(hub))
+	require.Equal(t, int32(2), hub.Status.Masters)
+	require.Equal(t, int32(4), hub.Status.Replicas)
+	require.Equal(t, "db.k8s.io/rediscluster=test", hub.Status.Selector)
+}
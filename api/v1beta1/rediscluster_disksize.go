@@ -0,0 +1,80 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Disk sizes were previously stored as integers with an implied unit of mebibytes. Objects
+// written with integer disk sizes are decoded here so that they keep their meaning as
+// quantities rather than being read as a number of bytes.
+
+// UnmarshalJSON decodes a RedisNodeSpec, converting integer disk sizes from mebibytes
+func (n *RedisNodeSpec) UnmarshalJSON(data []byte) error {
+	type redisNodeSpec RedisNodeSpec
+	raw := struct {
+		*redisNodeSpec
+		DiskSize json.RawMessage `json:"diskSize,omitempty"`
+	}{
+		redisNodeSpec: (*redisNodeSpec)(n),
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	return unmarshalDiskSize(raw.DiskSize, &n.DiskSize)
+}
+
+// UnmarshalJSON decodes a RedisNodeStatus, converting integer disk sizes from mebibytes
+func (n *RedisNodeStatus) UnmarshalJSON(data []byte) error {
+	type redisNodeStatus RedisNodeStatus
+	raw := struct {
+		*redisNodeStatus
+		DiskSize json.RawMessage `json:"diskSize,omitempty"`
+	}{
+		redisNodeStatus: (*redisNodeStatus)(n),
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	return unmarshalDiskSize(raw.DiskSize, &n.DiskSize)
+}
+
+func unmarshalDiskSize(data json.RawMessage, diskSize *resource.Quantity) error {
+	if len(data) == 0 || string(data) == "null" {
+		*diskSize = resource.Quantity{}
+		return nil
+	}
+
+	if data[0] == '"' {
+		return diskSize.UnmarshalJSON(data)
+	}
+
+	mebibytes, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	*diskSize = *resource.NewQuantity(mebibytes*1024*1024, resource.BinarySI)
+	return nil
+}
@@ -0,0 +1,50 @@
+// +build unit
+
+package v1beta1
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestRedisNodeDiskSizeUnmarshal(t *testing.T) {
+	t.Run("quantity", func(t *testing.T) {
+		node := RedisNodeSpec{}
+		require.NoError(t, json.Unmarshal([]byte(`{"name":"a","diskSize":"10Gi"}`), &node))
+		require.Equal(t, "a", node.Name)
+		require.Equal(t, 0, node.DiskSize.Cmp(resource.MustParse("10Gi")))
+	})
+
+	t.Run("legacy integer", func(t *testing.T) {
+		node := RedisNodeSpec{}
+		require.NoError(t, json.Unmarshal([]byte(`{"name":"a","diskSize":1024}`), &node))
+		require.Equal(t, "a", node.Name)
+		require.Equal(t, 0, node.DiskSize.Cmp(resource.MustParse("1Gi")))
+	})
+
+	t.Run("legacy integer in status", func(t *testing.T) {
+		node := RedisNodeStatus{}
+		require.NoError(t, json.Unmarshal([]byte(`{"ip":"1.2.3.4","diskSize":512,"ready":true}`), &node))
+		require.Equal(t, "1.2.3.4", node.IP)
+		require.True(t, node.Ready)
+		require.Equal(t, 0, node.DiskSize.Cmp(resource.MustParse("512Mi")))
+	})
+
+	t.Run("omitted", func(t *testing.T) {
+		node := RedisNodeSpec{}
+		require.NoError(t, json.Unmarshal([]byte(`{"name":"a"}`), &node))
+		require.True(t, node.DiskSize.IsZero())
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		data, err := json.Marshal(RedisNodeSpec{Name: "a", DiskSize: resource.MustParse("1536Mi")})
+		require.NoError(t, err)
+
+		node := RedisNodeSpec{}
+		require.NoError(t, json.Unmarshal(data, &node))
+		require.Equal(t, 0, node.DiskSize.Cmp(resource.MustParse("1536Mi")))
+	})
+}
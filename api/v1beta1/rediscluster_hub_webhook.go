@@ -0,0 +1,127 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	dbv1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// v1 RedisClusters are converted to v1beta1 to be defaulted and validated, so that both
+// versions are held to the same rules. The webhooks are registered by hand as the v1 type
+// can't implement webhook.Defaulter and webhook.Validator without importing this package.
+
+// +kubebuilder:webhook:path=/mutate-db-k8s-io-v1-rediscluster,mutating=true,failurePolicy=fail,groups=db.k8s.io,resources=redisclusters,verbs=create;update,versions=v1,name=mrediscluster-v1.kb.io
+// +kubebuilder:webhook:path=/validate-db-k8s-io-v1-rediscluster,mutating=false,failurePolicy=fail,groups=db.k8s.io,resources=redisclusters,verbs=create;update,versions=v1,name=vrediscluster-v1.kb.io
+
+// SetupHubWebhookWithManager registers the defaulting and validating webhooks of v1
+// RedisClusters with the manager's webhook server
+func SetupHubWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register("/mutate-db-k8s-io-v1-rediscluster", &webhook.Admission{Handler: &hubDefaulter{}})
+	mgr.GetWebhookServer().Register("/validate-db-k8s-io-v1-rediscluster", &webhook.Admission{Handler: &hubValidator{}})
+
+	return nil
+}
+
+// hubDefaulter defaults the spec of a v1 RedisCluster
+type hubDefaulter struct{}
+
+func (h *hubDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	hub := &dbv1.RedisCluster{}
+	if err := json.Unmarshal(req.Object.Raw, hub); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if err := defaultHub(hub); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	marshalled, err := json.Marshal(hub)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshalled)
+}
+
+// defaultHub defaults the spec of a v1 RedisCluster as its v1beta1 equivalent would be. Only
+// the spec is taken from the defaulted cluster, so that defaulting doesn't add the
+// annotation preserving the v1beta1 nodes.
+func defaultHub(hub *dbv1.RedisCluster) error {
+	redisCluster := &RedisCluster{}
+	if err := redisCluster.ConvertFrom(hub.DeepCopy()); err != nil {
+		return err
+	}
+
+	redisCluster.Default()
+
+	defaulted := &dbv1.RedisCluster{}
+	if err := redisCluster.ConvertTo(defaulted); err != nil {
+		return err
+	}
+	hub.Spec = defaulted.Spec
+
+	return nil
+}
+
+// hubValidator validates a v1 RedisCluster. Errors refer to the v1beta1 fields that the
+// offending v1 fields are converted to.
+type hubValidator struct{}
+
+func (h *hubValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	redisCluster, err := redisClusterFromHub(req.Object.Raw)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	switch req.Operation {
+	case admissionv1beta1.Create:
+		err = redisCluster.ValidateCreate()
+	case admissionv1beta1.Update:
+		old, decodeErr := redisClusterFromHub(req.OldObject.Raw)
+		if decodeErr != nil {
+			return admission.Errored(http.StatusBadRequest, decodeErr)
+		}
+		err = redisCluster.ValidateUpdate(old)
+	}
+	if err != nil {
+		return admission.Denied(err.Error())
+	}
+
+	return admission.Allowed("")
+}
+
+// redisClusterFromHub decodes a v1 RedisCluster and converts it to v1beta1
+func redisClusterFromHub(raw []byte) (*RedisCluster, error) {
+	hub := &dbv1.RedisCluster{}
+	if err := json.Unmarshal(raw, hub); err != nil {
+		return nil, err
+	}
+
+	redisCluster := &RedisCluster{}
+	if err := redisCluster.ConvertFrom(hub); err != nil {
+		return nil, err
+	}
+
+	return redisCluster, nil
+}
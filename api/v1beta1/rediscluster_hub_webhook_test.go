@@ -0,0 +1,100 @@
+// +build unit
+
+package v1beta1
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	dbv1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1"
+	"github.com/stretchr/testify/require"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func newTestHub() *dbv1.RedisCluster {
+	return &dbv1.RedisCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Spec: dbv1.RedisClusterSpec{
+			Masters:           3,
+			ReplicasPerMaster: 1,
+			Storage:           dbv1.RedisStorageSpec{Size: resource.MustParse("2Gi")},
+		},
+	}
+}
+
+func newHubAdmissionRequest(t *testing.T, operation admissionv1beta1.Operation, hub, old *dbv1.RedisCluster) admission.Request {
+	req := admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{Operation: operation}}
+
+	raw, err := json.Marshal(hub)
+	require.NoError(t, err)
+	req.Object = runtime.RawExtension{Raw: raw}
+
+	if old != nil {
+		raw, err := json.Marshal(old)
+		require.NoError(t, err)
+		req.OldObject = runtime.RawExtension{Raw: raw}
+	}
+
+	return req
+}
+
+func TestDefaultHub(t *testing.T) {
+	hub := newTestHub()
+	hub.Spec.Masters = 0
+	hub.Spec.Storage.Size = resource.Quantity{}
+
+	require.NoError(t, defaultHub(hub))
+	require.Equal(t, int32(MinMasters), hub.Spec.Masters)
+	require.Equal(t, int32(1), hub.Spec.ReplicasPerMaster)
+	require.Equal(t, 0, hub.Spec.Storage.Size.Cmp(DefaultDiskSize))
+	require.Equal(t, DefaultImage, hub.Spec.Image)
+	require.Equal(t, dbv1.PersistenceModeBoth, hub.Spec.Persistence.Mode)
+	require.NotNil(t, hub.Spec.RebalanceThreshold)
+	require.Empty(t, hub.Annotations)
+
+	response := (&hubDefaulter{}).Handle(context.TODO(), newHubAdmissionRequest(t, admissionv1beta1.Create, newTestHub(), nil))
+	require.True(t, response.Allowed)
+	require.NotEmpty(t, response.Patches)
+}
+
+func TestHubValidator(t *testing.T) {
+	validator := &hubValidator{}
+
+	t.Run("valid", func(t *testing.T) {
+		response := validator.Handle(context.TODO(), newHubAdmissionRequest(t, admissionv1beta1.Create, newTestHub(), nil))
+		require.True(t, response.Allowed)
+	})
+
+	t.Run("reserved override", func(t *testing.T) {
+		hub := newTestHub()
+		hub.Spec.Config.Overrides = map[string]string{"port": "6380"}
+
+		response := validator.Handle(context.TODO(), newHubAdmissionRequest(t, admissionv1beta1.Create, hub, nil))
+		require.False(t, response.Allowed)
+		require.Contains(t, string(response.Result.Reason), "overrides[port]")
+	})
+
+	t.Run("storage shrunk", func(t *testing.T) {
+		old := newTestHub()
+		hub := newTestHub()
+		hub.Spec.Storage.Size = resource.MustParse("1Gi")
+
+		response := validator.Handle(context.TODO(), newHubAdmissionRequest(t, admissionv1beta1.Update, hub, old))
+		require.False(t, response.Allowed)
+		require.Contains(t, string(response.Result.Reason), "volumes cannot be shrunk")
+	})
+
+	t.Run("masters added", func(t *testing.T) {
+		old := newTestHub()
+		hub := newTestHub()
+		hub.Spec.Masters = 4
+
+		response := validator.Handle(context.TODO(), newHubAdmissionRequest(t, admissionv1beta1.Update, hub, old))
+		require.True(t, response.Allowed)
+	})
+}
@@ -16,29 +16,443 @@ limitations under the License.
 package v1beta1
 
 import (
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// DefaultDiskSize is the disk size of nodes added by scaling a RedisCluster that has no
+// nodes to use as a template
+var DefaultDiskSize = resource.MustParse("1Gi")
+
+// DefaultImage is the redis image run by clusters that don't specify one. RedisUsers need
+// the ACLs introduced in redis 6.
+const DefaultImage = "redis:6.0.9"
+
+// DefaultRebalanceThreshold is the percentage by which a master's share of the hash slots may
+// differ from an even share when the cluster doesn't specify a threshold, matching the
+// default of redis-cli --cluster rebalance
+const DefaultRebalanceThreshold int32 = 2
+
+// DefaultRotationGracePeriod is how long nodes accept both the old and the new password when
+// the password of a cluster that doesn't specify a grace period changes
+const DefaultRotationGracePeriod = 5 * time.Minute
+
+// DefaultSaveSchedule is when nodes snapshot the dataset unless the cluster says otherwise,
+// matching the default of redis itself
+const DefaultSaveSchedule = "900 1 300 10 60 10000"
+
+// DefaultAppendFsync is how often nodes flush the append only file unless the cluster says
+// otherwise, matching the default of redis itself
+const DefaultAppendFsync = "everysec"
+
+// DefaultCertificateRenewBefore is how long before their certificates expire that the nodes
+// of a cluster that doesn't specify when are restarted with renewed ones
+const DefaultCertificateRenewBefore = 30 * 24 * time.Hour
+
+// DefaultResources returns the compute resources requested by the nodes of clusters that
+// don't specify any
+func DefaultResources() corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("100m"),
+			corev1.ResourceMemory: resource.MustParse("256Mi"),
+		},
+	}
+}
+
 type RedisNodeSpec struct {
-	DiskSize int `json:"diskSize,omitempty"`
+	// Name uniquely identifies the node within the cluster. Nodes without a name are named
+	// after their position in Nodes, e.g. "0", for compatibility with clusters created
+	// before nodes were named.
+	// +kubebuilder:validation:Pattern=^[a-z0-9]([-a-z0-9]*[a-z0-9])?$
+	// +kubebuilder:validation:MaxLength=40
+	Name string `json:"name,omitempty"`
+	// DiskSize is the size of the persistent volume backing the node, e.g. 10Gi. Integer
+	// values, as accepted by earlier versions of the API, are interpreted as mebibytes. It's
+	// ignored when persistence is None, as nodes have no persistent volume.
+	DiskSize resource.Quantity `json:"diskSize,omitempty"`
 }
 
 type RedisNodeStatus struct {
-	IP       string `json:"ip,omitempty"`
-	DiskSize int    `json:"diskSize,omitempty"`
+	// Name is the name of the node in the spec
+	Name string `json:"name,omitempty"`
+	// NodeID is the id the node is known by within the redis cluster
+	NodeID   string            `json:"nodeID,omitempty"`
+	IP       string            `json:"ip,omitempty"`
+	DiskSize resource.Quantity `json:"diskSize,omitempty"`
+	// Ready indicates that the node's pod is running and passing its readiness probe
+	Ready bool `json:"ready,omitempty"`
+	// StartTime is when the node's redis container last started. ACL users only live in
+	// memory, so they're reapplied to the node whenever it changes.
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// Joined indicates that the node has met the rest of the cluster
+	Joined bool `json:"joined,omitempty"`
+	// Role is whether the node is a master or a replica
+	Role RedisNodeRole `json:"role,omitempty"`
+	// MasterName is the name of the node a replica follows
+	MasterName string `json:"masterName,omitempty"`
+	// Slots is the number of hash slots served by the node
+	Slots int32 `json:"slots,omitempty"`
+	// Failed indicates that the rest of the cluster has flagged the node as failing
+	Failed bool `json:"failed,omitempty"`
+	// ConfigHash identifies the configuration last applied to the node
+	ConfigHash string `json:"configHash,omitempty"`
+	// AuthHash identifies the password the node requires
+	AuthHash string `json:"authHash,omitempty"`
+	// CertificateNotAfter is when the certificate served by the node expires
+	CertificateNotAfter *metav1.Time `json:"certificateNotAfter,omitempty"`
+}
+
+// RedisNodeRole is the role of a node within the redis cluster
+type RedisNodeRole string
+
+const (
+	// RedisNodeRoleMaster nodes serve hash slots
+	RedisNodeRoleMaster RedisNodeRole = "Master"
+	// RedisNodeRoleReplica nodes replicate a master, taking over from it should it fail
+	RedisNodeRoleReplica RedisNodeRole = "Replica"
+)
+
+// PVCRetentionPolicy describes what happens to the PVCs of a RedisCluster's nodes when the
+// RedisCluster is deleted
+type PVCRetentionPolicy string
+
+const (
+	// PVCRetentionPolicyRetain leaves PVCs in place, along with the data stored on them
+	PVCRetentionPolicyRetain PVCRetentionPolicy = "Retain"
+	// PVCRetentionPolicyDelete deletes PVCs along with the RedisCluster
+	PVCRetentionPolicyDelete PVCRetentionPolicy = "Delete"
+)
+
+// PersistenceMode describes how a node persists its dataset to disk
+type PersistenceMode string
+
+const (
+	// PersistenceModeAOF logs every write to an append only file
+	PersistenceModeAOF PersistenceMode = "AOF"
+	// PersistenceModeRDB periodically snapshots the dataset
+	PersistenceModeRDB PersistenceMode = "RDB"
+	// PersistenceModeBoth uses both an append only file and periodic snapshots
+	PersistenceModeBoth PersistenceMode = "Both"
+	// PersistenceModeNone keeps the dataset in memory only, e.g. for a cache. Nodes get an
+	// emptyDir rather than a persistent volume, so a node whose pod is recreated starts
+	// empty and rejoins the cluster as a new node.
+	PersistenceModeNone PersistenceMode = "None"
+)
+
+// RedisPersistence configures how nodes persist their data
+type RedisPersistence struct {
+	// Mode is the persistence strategy used by every node. Both is used when omitted. It
+	// can't be changed to or from None once the cluster exists.
+	// +kubebuilder:validation:Enum=AOF;RDB;Both;None
+	Mode PersistenceMode `json:"mode,omitempty"`
+
+	// SaveSchedule is when nodes snapshot the dataset in the RDB and Both modes, as pairs of
+	// seconds and changes: "900 1 300 10" snapshots once 900 seconds have passed with at
+	// least 1 change, or 300 seconds with at least 10.
+	SaveSchedule string `json:"saveSchedule,omitempty"`
+
+	// AppendFsync is how often the append only file is flushed to disk in the AOF and Both
+	// modes
+	// +kubebuilder:validation:Enum=always;everysec;no
+	AppendFsync string `json:"appendFsync,omitempty"`
+}
+
+// RedisConfig configures the redis server of every node. Settings that redis allows to be
+// changed at runtime are applied with CONFIG SET, the rest by restarting nodes one at a time.
+type RedisConfig struct {
+	// MaxMemory is the memory limit of the dataset, e.g. 1Gi, beyond which keys are evicted
+	// according to MaxMemoryPolicy. 0 means no limit.
+	MaxMemory *resource.Quantity `json:"maxMemory,omitempty"`
+	// MaxMemoryPolicy determines which keys are evicted once MaxMemory is reached
+	// +kubebuilder:validation:Enum=noeviction;allkeys-lru;volatile-lru;allkeys-lfu;volatile-lfu;allkeys-random;volatile-random;volatile-ttl
+	MaxMemoryPolicy string `json:"maxMemoryPolicy,omitempty"`
+	// MaxClients is the maximum number of clients connected to a node at once
+	// +kubebuilder:validation:Minimum=1
+	MaxClients *int32 `json:"maxClients,omitempty"`
+	// Timeout is the number of seconds after which idle clients are disconnected. 0 disables
+	// the timeout.
+	// +kubebuilder:validation:Minimum=0
+	Timeout *int32 `json:"timeout,omitempty"`
+	// TCPKeepAlive is the interval in seconds between TCP keepalives sent to clients. 0
+	// disables keepalives.
+	// +kubebuilder:validation:Minimum=0
+	TCPKeepAlive *int32 `json:"tcpKeepAlive,omitempty"`
+	// ClusterNodeTimeout is the number of milliseconds a node must be unreachable for before
+	// it's considered to be failing
+	// +kubebuilder:validation:Minimum=1
+	ClusterNodeTimeout *int32 `json:"clusterNodeTimeout,omitempty"`
+	// Overrides are additional redis.conf directives, e.g. slowlog-max-len: "256". Settings
+	// managed by the controller or by other fields can't be overridden. Removing an override
+	// that redis applied at runtime leaves it in effect until nodes restart.
+	Overrides map[string]string `json:"overrides,omitempty"`
+}
+
+// RedisAuth configures the password that clients, and replicas connecting to their master,
+// must authenticate with
+type RedisAuth struct {
+	// PasswordSecret selects the key of a Secret, in the cluster's namespace, holding the
+	// password. A Secret named <cluster>-password is generated when omitted.
+	PasswordSecret *corev1.SecretKeySelector `json:"passwordSecret,omitempty"`
+
+	// RotationGracePeriod is how long nodes accept both the old and the new password when
+	// the password changes, giving clients time to switch to the new one
+	RotationGracePeriod *metav1.Duration `json:"rotationGracePeriod,omitempty"`
+}
+
+// RedisTLS configures TLS for client, replication and cluster bus traffic
+type RedisTLS struct {
+	// CertificateSecret names a Secret, in the cluster's namespace, holding the certificate
+	// (tls.crt) and key (tls.key) served by every node and the CA certificate (ca.crt) they're
+	// verified with, such as one issued by cert-manager. When omitted, the controller creates
+	// a CA, stored in the <cluster>-ca Secret, and issues each node its own certificate.
+	CertificateSecret *corev1.LocalObjectReference `json:"certificateSecret,omitempty"`
+
+	// RenewBefore is how long before its certificate expires that a node is restarted with
+	// a renewed one. A referenced certificate must have been renewed by then.
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
 }
 
 // RedisClusterSpec defines the desired state of RedisCluster
 type RedisClusterSpec struct {
 	Nodes []RedisNodeSpec `json:"nodes,omitempty"`
+
+	// Replicas is the desired number of nodes. When set it takes precedence over the length
+	// of Nodes, allowing the cluster to be resized through the scale subresource.
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// ReplicasPerMaster is the number of replicas following each master. Nodes are grouped
+	// into shards of ReplicasPerMaster+1 consecutive nodes, one of which is the master that
+	// the others replicate.
+	// +kubebuilder:validation:Minimum=0
+	ReplicasPerMaster int32 `json:"replicasPerMaster,omitempty"`
+
+	// StorageClassName is the storage class used for each node's persistent volume claim.
+	// The cluster's default storage class is used when omitted.
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// PVCRetentionPolicy determines whether node PVCs are deleted along with the cluster.
+	// PVCs are retained when omitted.
+	// +kubebuilder:validation:Enum=Retain;Delete
+	PVCRetentionPolicy PVCRetentionPolicy `json:"pvcRetentionPolicy,omitempty"`
+
+	// Image is the redis image run by every node
+	Image string `json:"image,omitempty"`
+
+	// Resources are the compute resources of each node's redis container
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Persistence configures how nodes persist their data
+	Persistence RedisPersistence `json:"persistence,omitempty"`
+
+	// Config configures the redis server of every node
+	Config RedisConfig `json:"config,omitempty"`
+
+	// Auth requires clients to authenticate with a password. Nodes accept any client when
+	// omitted.
+	Auth *RedisAuth `json:"auth,omitempty"`
+
+	// TLS requires clients, replicas and the cluster bus to connect over TLS. Nodes only
+	// accept plaintext connections when omitted. It can't be enabled or disabled once the
+	// cluster exists.
+	TLS *RedisTLS `json:"tls,omitempty"`
+
+	// RebalanceThreshold is the percentage by which the number of hash slots served by a
+	// master may differ from an even share before slots are migrated between masters to
+	// even out the distribution. Defaults to 2.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	RebalanceThreshold *int32 `json:"rebalanceThreshold,omitempty"`
+}
+
+// DesiredNodes returns the nodes the cluster should consist of, with every node named. When
+// Replicas is set, Nodes is truncated or extended to that length, with additional nodes
+// copying the last node in Nodes.
+func (s *RedisClusterSpec) DesiredNodes() []RedisNodeSpec {
+	count := len(s.Nodes)
+	if s.Replicas != nil {
+		count = int(*s.Replicas)
+		if count < 0 {
+			count = 0
+		}
+	}
+
+	nodes := make([]RedisNodeSpec, count)
+	for i := 0; i < count && i < len(s.Nodes); i++ {
+		s.Nodes[i].DeepCopyInto(&nodes[i])
+	}
+
+	taken := map[string]bool{}
+	for i := 0; i < count && i < len(s.Nodes); i++ {
+		if nodes[i].Name == "" {
+			nodes[i].Name = strconv.Itoa(i)
+		}
+		taken[nodes[i].Name] = true
+	}
+
+	template := RedisNodeSpec{DiskSize: DefaultDiskSize.DeepCopy()}
+	if len(s.Nodes) > 0 {
+		template = *s.Nodes[len(s.Nodes)-1].DeepCopy()
+	}
+
+	next := len(s.Nodes)
+	for i := len(s.Nodes); i < count; i++ {
+		for taken[strconv.Itoa(next)] {
+			next++
+		}
+
+		template.DeepCopyInto(&nodes[i])
+		nodes[i].Name = strconv.Itoa(next)
+		taken[nodes[i].Name] = true
+	}
+
+	return nodes
+}
+
+// Shards groups the desired nodes into shards of ReplicasPerMaster+1 consecutive nodes
+func (s *RedisClusterSpec) Shards() [][]RedisNodeSpec {
+	nodes := s.DesiredNodes()
+	size := int(s.ReplicasPerMaster) + 1
+	if size < 1 {
+		size = 1
+	}
+
+	shards := [][]RedisNodeSpec{}
+	for start := 0; start < len(nodes); start += size {
+		end := start + size
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+		shards = append(shards, nodes[start:end])
+	}
+
+	return shards
+}
+
+// RedisClusterConditionType is a valid value for RedisClusterCondition.Type
+type RedisClusterConditionType string
+
+const (
+	// RedisClusterReady means the cluster has converged on its spec and every node is serving
+	RedisClusterReady RedisClusterConditionType = "Ready"
+	// RedisClusterProgressing means the controller is performing an action to converge on the spec
+	RedisClusterProgressing RedisClusterConditionType = "Progressing"
+	// RedisClusterDegraded means one or more nodes are not ready
+	RedisClusterDegraded RedisClusterConditionType = "Degraded"
+	// RedisClusterReconcileError means the last reconciliation failed
+	RedisClusterReconcileError RedisClusterConditionType = "ReconcileError"
+	// RedisClusterPasswordRotating means a new password is being rolled out to the nodes
+	RedisClusterPasswordRotating RedisClusterConditionType = "PasswordRotating"
+)
+
+// RedisClusterCondition describes the state of a RedisCluster at a certain point
+type RedisClusterCondition struct {
+	Type   RedisClusterConditionType `json:"type"`
+	Status corev1.ConditionStatus    `json:"status"`
+	// LastTransitionTime is the last time the condition changed from one status to another
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a one word, CamelCase reason for the condition's last transition
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable description of the details of the last transition
+	Message string `json:"message,omitempty"`
+}
+
+// RedisRebalanceStatus reports the progress of a slot rebalance
+type RedisRebalanceStatus struct {
+	// SlotsMoved is the number of slots migrated since the rebalance started
+	SlotsMoved int32 `json:"slotsMoved"`
+	// SlotsRemaining is the number of slots still to be migrated
+	SlotsRemaining int32 `json:"slotsRemaining"`
+	// StartTime is when the rebalance started
+	StartTime metav1.Time `json:"startTime,omitempty"`
+}
+
+// RedisPasswordRotationPhase is the stage a password rotation has reached
+type RedisPasswordRotationPhase string
+
+const (
+	// RedisPasswordRotationAdding means the new password is being added to every node
+	// alongside the old one
+	RedisPasswordRotationAdding RedisPasswordRotationPhase = "AddingPassword"
+	// RedisPasswordRotationGracePeriod means every node accepts both passwords, and the old
+	// one is removed once the grace period has passed
+	RedisPasswordRotationGracePeriod RedisPasswordRotationPhase = "GracePeriod"
+)
+
+// RedisPasswordRotation reports the progress of rolling out a new password
+type RedisPasswordRotation struct {
+	// AuthHash identifies the password being rolled out
+	AuthHash string `json:"authHash"`
+	// Phase is the stage the rotation has reached
+	Phase RedisPasswordRotationPhase `json:"phase"`
+	// GracePeriodStartTime is when every node started accepting the new password
+	GracePeriodStartTime *metav1.Time `json:"gracePeriodStartTime,omitempty"`
 }
 
 // RedisClusterStatus defines the observed state of RedisCluster
 type RedisClusterStatus struct {
 	Nodes []RedisNodeStatus `json:"nodes,omitempty"`
+
+	// Replicas is the number of nodes that currently exist
+	Replicas int32 `json:"replicas"`
+	// Selector is the label selector matching the pods of the cluster's nodes
+	Selector string `json:"selector,omitempty"`
+
+	// OpenSlots is the number of slots that nodes have left migrating or importing, e.g.
+	// because a migration was interrupted
+	OpenSlots int32 `json:"openSlots,omitempty"`
+	// UncoveredSlots is the number of slots that no node serves
+	UncoveredSlots int32 `json:"uncoveredSlots,omitempty"`
+
+	// Rebalance reports the progress of the slot rebalance underway, if any
+	Rebalance *RedisRebalanceStatus `json:"rebalance,omitempty"`
+
+	// AuthHash identifies the password nodes should require, without revealing it
+	AuthHash string `json:"authHash,omitempty"`
+	// PasswordRotation reports the progress of the password rotation underway, if any
+	PasswordRotation *RedisPasswordRotation `json:"passwordRotation,omitempty"`
+
+	// ObservedGeneration is the most recent generation of the spec acted on by the controller
+	ObservedGeneration int64                   `json:"observedGeneration,omitempty"`
+	Conditions         []RedisClusterCondition `json:"conditions,omitempty"`
+}
+
+// GetCondition returns the condition of the given type or nil if it hasn't been set
+func (s *RedisClusterStatus) GetCondition(conditionType RedisClusterConditionType) *RedisClusterCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == conditionType {
+			return &s.Conditions[i]
+		}
+	}
+
+	return nil
+}
+
+// SetCondition adds or updates the condition of the given type. The transition time is
+// only updated when the status of the condition changes.
+func (s *RedisClusterStatus) SetCondition(conditionType RedisClusterConditionType, status corev1.ConditionStatus, reason, message string) {
+	condition := s.GetCondition(conditionType)
+	if condition == nil {
+		s.Conditions = append(s.Conditions, RedisClusterCondition{Type: conditionType})
+		condition = &s.Conditions[len(s.Conditions)-1]
+	}
+
+	if condition.Status != status {
+		condition.Status = status
+		condition.LastTransitionTime = metav1.Now()
+	}
+
+	condition.Reason = reason
+	condition.Message = message
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.status.selector
 
 // RedisCluster is the Schema for the redisclusters API
 type RedisCluster struct {
@@ -0,0 +1,342 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// MinMasters is the smallest number of masters a RedisCluster may have. Redis Cluster needs
+// a majority of at least three masters to agree on failures.
+const MinMasters = 3
+
+var redisclusterlog = logf.Log.WithName("rediscluster-resource")
+
+// +kubebuilder:webhook:path=/mutate-db-k8s-io-v1beta1-rediscluster,mutating=true,failurePolicy=fail,groups=db.k8s.io,resources=redisclusters,verbs=create;update,versions=v1beta1,name=mrediscluster.kb.io
+
+var _ webhook.Defaulter = &RedisCluster{}
+
+// Default implements webhook.Defaulter
+func (r *RedisCluster) Default() {
+	redisclusterlog.Info("default", "name", r.Name)
+
+	r.Spec.Default()
+}
+
+// Default explicitly sets every unset field of the spec to its default, so that the stored
+// object describes exactly what the controller will run
+func (s *RedisClusterSpec) Default() {
+	if len(s.Nodes) == 0 && s.Replicas == nil {
+		replicas := MinMasters * (s.ReplicasPerMaster + 1)
+		s.Nodes = (&RedisClusterSpec{Replicas: &replicas}).DesiredNodes()
+	}
+	for i := range s.Nodes {
+		if s.Nodes[i].Name == "" {
+			s.Nodes[i].Name = strconv.Itoa(i)
+		}
+		if s.Nodes[i].DiskSize.IsZero() {
+			s.Nodes[i].DiskSize = DefaultDiskSize.DeepCopy()
+		}
+	}
+
+	if s.Image == "" {
+		s.Image = DefaultImage
+	}
+	if len(s.Resources.Requests) == 0 && len(s.Resources.Limits) == 0 {
+		s.Resources = DefaultResources()
+	}
+	// both matches the behaviour of clusters created before the mode could be chosen
+	if s.Persistence.Mode == "" {
+		s.Persistence.Mode = PersistenceModeBoth
+	}
+	if s.Persistence.SaveSchedule == "" && (s.Persistence.Mode == PersistenceModeRDB || s.Persistence.Mode == PersistenceModeBoth) {
+		s.Persistence.SaveSchedule = DefaultSaveSchedule
+	}
+	if s.Persistence.AppendFsync == "" && (s.Persistence.Mode == PersistenceModeAOF || s.Persistence.Mode == PersistenceModeBoth) {
+		s.Persistence.AppendFsync = DefaultAppendFsync
+	}
+	s.Config.Default()
+	if s.Auth != nil && s.Auth.RotationGracePeriod == nil {
+		s.Auth.RotationGracePeriod = &metav1.Duration{Duration: DefaultRotationGracePeriod}
+	}
+	if s.TLS != nil && s.TLS.RenewBefore == nil {
+		s.TLS.RenewBefore = &metav1.Duration{Duration: DefaultCertificateRenewBefore}
+	}
+	if s.RebalanceThreshold == nil {
+		threshold := DefaultRebalanceThreshold
+		s.RebalanceThreshold = &threshold
+	}
+}
+
+// Default sets every unset setting to the value redis itself defaults to, so that a setting
+// that is removed is restored to its default on nodes that applied it at runtime
+func (c *RedisConfig) Default() {
+	if c.MaxMemory == nil {
+		maxMemory := resource.MustParse("0")
+		c.MaxMemory = &maxMemory
+	}
+	if c.MaxMemoryPolicy == "" {
+		c.MaxMemoryPolicy = "noeviction"
+	}
+	if c.MaxClients == nil {
+		maxClients := int32(10000)
+		c.MaxClients = &maxClients
+	}
+	if c.Timeout == nil {
+		timeout := int32(0)
+		c.Timeout = &timeout
+	}
+	if c.TCPKeepAlive == nil {
+		tcpKeepAlive := int32(300)
+		c.TCPKeepAlive = &tcpKeepAlive
+	}
+	if c.ClusterNodeTimeout == nil {
+		clusterNodeTimeout := int32(15000)
+		c.ClusterNodeTimeout = &clusterNodeTimeout
+	}
+}
+
+// +kubebuilder:webhook:path=/validate-db-k8s-io-v1beta1-rediscluster,mutating=false,failurePolicy=fail,groups=db.k8s.io,resources=redisclusters,verbs=create;update,versions=v1beta1,name=vrediscluster.kb.io
+
+var _ webhook.Validator = &RedisCluster{}
+
+// ValidateCreate implements webhook.Validator
+func (r *RedisCluster) ValidateCreate() error {
+	redisclusterlog.Info("validate create", "name", r.Name)
+
+	return r.validate(nil)
+}
+
+// ValidateUpdate implements webhook.Validator
+func (r *RedisCluster) ValidateUpdate(old runtime.Object) error {
+	redisclusterlog.Info("validate update", "name", r.Name)
+
+	oldCluster, ok := old.(*RedisCluster)
+	if !ok {
+		return fmt.Errorf("expected a RedisCluster but got a %T", old)
+	}
+	return r.validate(oldCluster)
+}
+
+func (r *RedisCluster) validate(old *RedisCluster) error {
+	// a cluster being torn down only has its finalizers removed, which must never be blocked
+	if r.DeletionTimestamp != nil {
+		return nil
+	}
+
+	var oldSpec *RedisClusterSpec
+	if old != nil {
+		// objects accepted before validation existed must still be able to have their
+		// metadata updated, so the spec is only validated when it's new or has changed.
+		// The old spec is compared once defaulted, as the new one will have been.
+		oldSpec = old.Spec.DeepCopy()
+		oldSpec.Default()
+		if equality.Semantic.DeepEqual(r.Spec, *oldSpec) {
+			return nil
+		}
+	}
+
+	errs := r.Spec.validate(field.NewPath("spec"))
+	if oldSpec != nil {
+		errs = append(errs, r.Spec.validateUpdate(oldSpec, field.NewPath("spec"))...)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("RedisCluster").GroupKind(), r.Name, errs)
+}
+
+func (s *RedisClusterSpec) validate(path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	if s.Replicas != nil && *s.Replicas < 0 {
+		errs = append(errs, field.Invalid(path.Child("replicas"), *s.Replicas, "must be greater than or equal to 0"))
+	}
+
+	if s.RebalanceThreshold != nil && (*s.RebalanceThreshold < 0 || *s.RebalanceThreshold > 100) {
+		errs = append(errs, field.Invalid(path.Child("rebalanceThreshold"), *s.RebalanceThreshold, "must be a percentage between 0 and 100"))
+	}
+
+	nodes := s.DesiredNodes()
+	shardSize := int(s.ReplicasPerMaster) + 1
+	switch {
+	case s.ReplicasPerMaster < 0:
+		errs = append(errs, field.Invalid(path.Child("replicasPerMaster"), s.ReplicasPerMaster, "must be greater than or equal to 0"))
+	case len(nodes) > 0 && int(s.ReplicasPerMaster) >= len(nodes):
+		errs = append(errs, field.Invalid(path.Child("replicasPerMaster"), s.ReplicasPerMaster, "must be less than the number of nodes"))
+	case len(nodes)%shardSize != 0:
+		errs = append(errs, field.Invalid(path.Child("nodes"), len(nodes), "the number of nodes must be a multiple of replicasPerMaster+1"))
+	case len(nodes)/shardSize < MinMasters:
+		errs = append(errs, field.Invalid(path.Child("nodes"), len(nodes), fmt.Sprintf("a redis cluster requires at least %d masters", MinMasters)))
+	}
+
+	errs = append(errs, s.Persistence.validate(path.Child("persistence"))...)
+	errs = append(errs, s.Config.validate(path.Child("config"))...)
+
+	if s.Auth != nil && s.Auth.RotationGracePeriod != nil && s.Auth.RotationGracePeriod.Duration < 0 {
+		errs = append(errs, field.Invalid(path.Child("auth", "rotationGracePeriod"), s.Auth.RotationGracePeriod.Duration.String(), "must be greater than or equal to 0"))
+	}
+
+	if s.TLS != nil && s.TLS.RenewBefore != nil && s.TLS.RenewBefore.Duration < 0 {
+		errs = append(errs, field.Invalid(path.Child("tls", "renewBefore"), s.TLS.RenewBefore.Duration.String(), "must be greater than or equal to 0"))
+	}
+	if s.TLS != nil && s.TLS.CertificateSecret != nil && s.TLS.CertificateSecret.Name == "" {
+		errs = append(errs, field.Required(path.Child("tls", "certificateSecret", "name"), "must name a secret"))
+	}
+
+	names := map[string]bool{}
+	for i, node := range nodes {
+		nodePath := path.Child("nodes").Index(i)
+		if names[node.Name] {
+			errs = append(errs, field.Duplicate(nodePath.Child("name"), node.Name))
+		}
+		names[node.Name] = true
+
+		if node.DiskSize.Sign() <= 0 {
+			errs = append(errs, field.Invalid(nodePath.Child("diskSize"), node.DiskSize.String(), "must be greater than 0"))
+		}
+	}
+
+	return errs
+}
+
+// reservedRedisConfig are the redis.conf directives that can't be overridden, along with
+// where they're set instead
+var reservedRedisConfig = map[string]string{
+	"port":                 "set by the controller",
+	"cluster-enabled":      "set by the controller",
+	"cluster-config-file":  "set by the controller",
+	"dir":                  "set by the controller",
+	"daemonize":            "set by the controller",
+	"include":              "set by the controller",
+	"requirepass":          "set through spec.auth",
+	"masterauth":           "set through spec.auth",
+	"masteruser":           "set through spec.auth",
+	"user":                 "set through RedisUser resources",
+	"aclfile":              "set through RedisUser resources",
+	"tls-port":             "set through spec.tls",
+	"tls-cluster":          "set through spec.tls",
+	"tls-replication":      "set through spec.tls",
+	"tls-cert-file":        "set through spec.tls",
+	"tls-key-file":         "set through spec.tls",
+	"tls-ca-cert-file":     "set through spec.tls",
+	"tls-ca-cert-dir":      "set through spec.tls",
+	"tls-auth-clients":     "set through spec.tls",
+	"appendonly":           "set through spec.persistence",
+	"appendfsync":          "set through spec.persistence",
+	"save":                 "set through spec.persistence",
+	"maxmemory":            "set through spec.config.maxMemory",
+	"maxmemory-policy":     "set through spec.config.maxMemoryPolicy",
+	"maxclients":           "set through spec.config.maxClients",
+	"timeout":              "set through spec.config.timeout",
+	"tcp-keepalive":        "set through spec.config.tcpKeepAlive",
+	"cluster-node-timeout": "set through spec.config.clusterNodeTimeout",
+}
+
+func (p *RedisPersistence) validate(path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	// a schedule is pairs of seconds and changes, each of which must be positive
+	fields := strings.Fields(p.SaveSchedule)
+	valid := len(fields)%2 == 0
+	for _, f := range fields {
+		if n, err := strconv.Atoi(f); err != nil || n <= 0 {
+			valid = false
+		}
+	}
+	if !valid {
+		errs = append(errs, field.Invalid(path.Child("saveSchedule"), p.SaveSchedule, "must be pairs of positive seconds and changes, e.g. \"900 1 300 10\""))
+	}
+
+	return errs
+}
+
+var redisConfigNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+func (c *RedisConfig) validate(path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	if c.MaxMemory != nil && c.MaxMemory.Sign() < 0 {
+		errs = append(errs, field.Invalid(path.Child("maxMemory"), c.MaxMemory.String(), "must be greater than or equal to 0"))
+	}
+
+	names := make([]string, 0, len(c.Overrides))
+	for name := range c.Overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		overridePath := path.Child("overrides").Key(name)
+		if reason, ok := reservedRedisConfig[name]; ok {
+			errs = append(errs, field.Forbidden(overridePath, reason))
+		} else if !redisConfigNamePattern.MatchString(name) {
+			errs = append(errs, field.Invalid(overridePath, name, "must be a lower case redis.conf directive"))
+		}
+
+		if strings.ContainsAny(c.Overrides[name], "\r\n") {
+			errs = append(errs, field.Invalid(overridePath, c.Overrides[name], "must be a single line"))
+		}
+	}
+
+	return errs
+}
+
+func (s *RedisClusterSpec) validateUpdate(old *RedisClusterSpec, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	if !equality.Semantic.DeepEqual(s.StorageClassName, old.StorageClassName) {
+		errs = append(errs, field.Forbidden(path.Child("storageClassName"), "field is immutable"))
+	}
+	// nodes can't be moved between persistent volumes and emptyDirs without losing their data
+	if (s.Persistence.Mode == PersistenceModeNone) != (old.Persistence.Mode == PersistenceModeNone) {
+		errs = append(errs, field.Forbidden(path.Child("persistence", "mode"), "can't be changed to or from None once the cluster exists"))
+	}
+	// nodes with and without tls can't talk to each other on the cluster bus
+	if (s.TLS == nil) != (old.TLS == nil) {
+		errs = append(errs, field.Forbidden(path.Child("tls"), "tls can't be enabled or disabled once the cluster exists"))
+	}
+
+	oldNodes := map[string]RedisNodeSpec{}
+	for _, node := range old.DesiredNodes() {
+		oldNodes[node.Name] = node
+	}
+	for i, node := range s.DesiredNodes() {
+		oldNode, ok := oldNodes[node.Name]
+		if !ok || node.DiskSize.Cmp(oldNode.DiskSize) >= 0 {
+			continue
+		}
+		errs = append(errs, field.Forbidden(
+			path.Child("nodes").Index(i).Child("diskSize"),
+			fmt.Sprintf("volumes cannot be shrunk from %s to %s", oldNode.DiskSize.String(), node.DiskSize.String()),
+		))
+	}
+
+	return errs
+}
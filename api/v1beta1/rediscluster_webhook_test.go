@@ -0,0 +1,363 @@
+// +build unit
+
+package v1beta1
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestRedisCluster(diskSizes ...string) *RedisCluster {
+	rc := &RedisCluster{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+	for _, size := range diskSizes {
+		rc.Spec.Nodes = append(rc.Spec.Nodes, RedisNodeSpec{DiskSize: resource.MustParse(size)})
+	}
+	return rc
+}
+
+func TestRedisClusterValidateCreate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		require.NoError(t, newTestRedisCluster("1Gi", "1Gi", "1Gi").ValidateCreate())
+	})
+
+	t.Run("too few nodes", func(t *testing.T) {
+		err := newTestRedisCluster("1Gi", "1Gi").ValidateCreate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.nodes")
+	})
+
+	t.Run("replicas per master", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi", "1Gi", "1Gi", "1Gi")
+		rc.Spec.ReplicasPerMaster = 1
+		require.NoError(t, rc.ValidateCreate())
+	})
+
+	t.Run("replicas per master not less than nodes", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		rc.Spec.ReplicasPerMaster = 3
+		err := rc.ValidateCreate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.replicasPerMaster")
+	})
+
+	t.Run("nodes not divisible into shards", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi", "1Gi", "1Gi", "1Gi", "1Gi")
+		rc.Spec.ReplicasPerMaster = 1
+		err := rc.ValidateCreate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "replicasPerMaster+1")
+	})
+
+	t.Run("too few masters", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi", "1Gi")
+		rc.Spec.ReplicasPerMaster = 1
+		err := rc.ValidateCreate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "at least 3 masters")
+	})
+
+	t.Run("enough nodes via replicas", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi")
+		replicas := int32(3)
+		rc.Spec.Replicas = &replicas
+		require.NoError(t, rc.ValidateCreate())
+	})
+
+	t.Run("negative replicas", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		replicas := int32(-1)
+		rc.Spec.Replicas = &replicas
+		err := rc.ValidateCreate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.replicas")
+	})
+
+	t.Run("non-positive disk size", func(t *testing.T) {
+		err := newTestRedisCluster("1Gi", "0", "1Gi").ValidateCreate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.nodes[1].diskSize")
+	})
+
+	t.Run("rebalance threshold out of range", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		threshold := int32(101)
+		rc.Spec.RebalanceThreshold = &threshold
+		err := rc.ValidateCreate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.rebalanceThreshold")
+	})
+
+	t.Run("config overrides", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		rc.Spec.Config.Overrides = map[string]string{"slowlog-max-len": "256", "notify-keyspace-events": ""}
+		require.NoError(t, rc.ValidateCreate())
+	})
+
+	t.Run("reserved config override", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		rc.Spec.Config.Overrides = map[string]string{"maxmemory": "1gb"}
+		err := rc.ValidateCreate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.config.overrides[maxmemory]")
+		require.Contains(t, err.Error(), "spec.config.maxMemory")
+	})
+
+	t.Run("password config override", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		rc.Spec.Config.Overrides = map[string]string{"requirepass": "secret"}
+		err := rc.ValidateCreate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.config.overrides[requirepass]")
+		require.Contains(t, err.Error(), "spec.auth")
+	})
+
+	t.Run("invalid config override", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		rc.Spec.Config.Overrides = map[string]string{"Bad Name": "1", "rename-command": "FLUSHALL \"\"\nport 1234"}
+		err := rc.ValidateCreate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.config.overrides[Bad Name]")
+		require.Contains(t, err.Error(), "must be a single line")
+	})
+
+	t.Run("negative max memory", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		maxMemory := resource.MustParse("-1Mi")
+		rc.Spec.Config.MaxMemory = &maxMemory
+		err := rc.ValidateCreate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.config.maxMemory")
+	})
+
+	t.Run("negative rotation grace period", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		rc.Spec.Auth = &RedisAuth{RotationGracePeriod: &metav1.Duration{Duration: -time.Minute}}
+		err := rc.ValidateCreate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.auth.rotationGracePeriod")
+	})
+
+	t.Run("invalid save schedule", func(t *testing.T) {
+		for _, schedule := range []string{"900", "900 0", "900 1 300 ten"} {
+			rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+			rc.Spec.Persistence = RedisPersistence{Mode: PersistenceModeRDB, SaveSchedule: schedule}
+			err := rc.ValidateCreate()
+			require.Error(t, err, schedule)
+			require.Contains(t, err.Error(), "spec.persistence.saveSchedule")
+		}
+	})
+
+	t.Run("tls config override", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		rc.Spec.Config.Overrides = map[string]string{"tls-cluster": "no"}
+		err := rc.ValidateCreate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.config.overrides[tls-cluster]")
+		require.Contains(t, err.Error(), "spec.tls")
+	})
+
+	t.Run("negative renew before", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		rc.Spec.TLS = &RedisTLS{RenewBefore: &metav1.Duration{Duration: -time.Hour}}
+		err := rc.ValidateCreate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.tls.renewBefore")
+	})
+
+	t.Run("duplicate names", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		rc.Spec.Nodes[0].Name = "a"
+		rc.Spec.Nodes[2].Name = "a"
+		err := rc.ValidateCreate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.nodes[2].name")
+	})
+}
+
+func TestRedisClusterValidateUpdate(t *testing.T) {
+	t.Run("grow disk", func(t *testing.T) {
+		old := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		rc := newTestRedisCluster("1Gi", "2Gi", "1Gi")
+		require.NoError(t, rc.ValidateUpdate(old))
+	})
+
+	t.Run("shrink disk", func(t *testing.T) {
+		old := newTestRedisCluster("1Gi", "2Gi", "1Gi")
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		err := rc.ValidateUpdate(old)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.nodes[1].diskSize")
+	})
+
+	t.Run("replace node with smaller disk", func(t *testing.T) {
+		old := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		old.Spec.Nodes[2].Name = "c"
+		rc := newTestRedisCluster("1Gi", "1Gi", "512Mi")
+		rc.Spec.Nodes[2].Name = "d"
+		require.NoError(t, rc.ValidateUpdate(old))
+	})
+
+	t.Run("change storage class", func(t *testing.T) {
+		old := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		rc := newTestRedisCluster("1Gi", "1Gi", "2Gi")
+		storageClassName := "fast"
+		rc.Spec.StorageClassName = &storageClassName
+		err := rc.ValidateUpdate(old)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.storageClassName")
+	})
+
+	t.Run("stop persisting", func(t *testing.T) {
+		old := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		rc := old.DeepCopy()
+		rc.Spec.Persistence.Mode = PersistenceModeNone
+		err := rc.ValidateUpdate(old)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.persistence.mode")
+
+		// persisting nodes can switch between modes
+		rc.Spec.Persistence.Mode = PersistenceModeRDB
+		require.NoError(t, rc.ValidateUpdate(old))
+	})
+
+	t.Run("enable tls", func(t *testing.T) {
+		old := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		rc := old.DeepCopy()
+		rc.Spec.TLS = &RedisTLS{}
+		err := rc.ValidateUpdate(old)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.tls")
+
+		// the certificate can change once tls is enabled
+		old = rc.DeepCopy()
+		rc.Spec.TLS.CertificateSecret = &corev1.LocalObjectReference{Name: "test-tls"}
+		require.NoError(t, rc.ValidateUpdate(old))
+	})
+
+	t.Run("unchanged legacy spec", func(t *testing.T) {
+		old := newTestRedisCluster("1Gi")
+		rc := old.DeepCopy()
+		rc.Default()
+		rc.Finalizers = []string{"db.k8s.io/teardown"}
+		require.NoError(t, rc.ValidateUpdate(old))
+	})
+
+	t.Run("deleting", func(t *testing.T) {
+		old := newTestRedisCluster("1Gi")
+		rc := old.DeepCopy()
+		now := metav1.Now()
+		rc.DeletionTimestamp = &now
+		rc.Spec.Nodes = nil
+		require.NoError(t, rc.ValidateUpdate(old))
+	})
+}
+
+func TestRedisClusterDefault(t *testing.T) {
+	t.Run("empty spec", func(t *testing.T) {
+		rc := &RedisCluster{}
+		rc.Default()
+
+		require.Nil(t, rc.Spec.Replicas)
+		require.Len(t, rc.Spec.Nodes, MinMasters)
+		for i, node := range rc.Spec.Nodes {
+			require.Equal(t, strconv.Itoa(i), node.Name)
+			require.Equal(t, 0, node.DiskSize.Cmp(DefaultDiskSize))
+		}
+		require.Equal(t, DefaultImage, rc.Spec.Image)
+		require.Equal(t, 0, rc.Spec.Resources.Requests.Memory().Cmp(resource.MustParse("256Mi")))
+		require.Equal(t, PersistenceModeBoth, rc.Spec.Persistence.Mode)
+		require.Equal(t, DefaultRebalanceThreshold, *rc.Spec.RebalanceThreshold)
+		require.Equal(t, 0, rc.Spec.Config.MaxMemory.Cmp(resource.MustParse("0")))
+		require.Equal(t, "noeviction", rc.Spec.Config.MaxMemoryPolicy)
+		require.Equal(t, int32(15000), *rc.Spec.Config.ClusterNodeTimeout)
+		require.NoError(t, rc.ValidateCreate())
+	})
+
+	t.Run("nodes without names or disk sizes", func(t *testing.T) {
+		rc := newTestRedisCluster("2Gi", "0", "2Gi")
+		rc.Spec.Nodes[1].Name = "b"
+		rc.Default()
+
+		require.Equal(t, "0", rc.Spec.Nodes[0].Name)
+		require.Equal(t, "b", rc.Spec.Nodes[1].Name)
+		require.Equal(t, "2", rc.Spec.Nodes[2].Name)
+		require.Equal(t, 0, rc.Spec.Nodes[1].DiskSize.Cmp(DefaultDiskSize))
+		require.Equal(t, 0, rc.Spec.Nodes[2].DiskSize.Cmp(resource.MustParse("2Gi")))
+	})
+
+	t.Run("empty spec with replicas per master", func(t *testing.T) {
+		rc := &RedisCluster{}
+		rc.Spec.ReplicasPerMaster = 2
+		rc.Default()
+
+		require.Len(t, rc.Spec.Nodes, 9)
+		require.NoError(t, rc.ValidateCreate())
+	})
+
+	t.Run("replicas without nodes", func(t *testing.T) {
+		rc := &RedisCluster{}
+		replicas := int32(5)
+		rc.Spec.Replicas = &replicas
+		rc.Default()
+
+		require.Empty(t, rc.Spec.Nodes)
+		require.Len(t, rc.Spec.DesiredNodes(), 5)
+	})
+
+	t.Run("auth", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		rc.Default()
+		require.Nil(t, rc.Spec.Auth)
+
+		rc.Spec.Auth = &RedisAuth{}
+		rc.Default()
+		require.Equal(t, DefaultRotationGracePeriod, rc.Spec.Auth.RotationGracePeriod.Duration)
+	})
+
+	t.Run("persistence", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		rc.Default()
+		require.Equal(t, DefaultSaveSchedule, rc.Spec.Persistence.SaveSchedule)
+		require.Equal(t, DefaultAppendFsync, rc.Spec.Persistence.AppendFsync)
+
+		// settings of the modes that aren't used are left unset
+		for mode, expected := range map[PersistenceMode]RedisPersistence{
+			PersistenceModeAOF:  {Mode: PersistenceModeAOF, AppendFsync: DefaultAppendFsync},
+			PersistenceModeRDB:  {Mode: PersistenceModeRDB, SaveSchedule: DefaultSaveSchedule},
+			PersistenceModeNone: {Mode: PersistenceModeNone},
+		} {
+			rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+			rc.Spec.Persistence.Mode = mode
+			rc.Default()
+			require.Equal(t, expected, rc.Spec.Persistence)
+		}
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		rc.Default()
+		require.Nil(t, rc.Spec.TLS)
+
+		rc.Spec.TLS = &RedisTLS{}
+		rc.Default()
+		require.Equal(t, DefaultCertificateRenewBefore, rc.Spec.TLS.RenewBefore.Duration)
+	})
+
+	t.Run("explicit values", func(t *testing.T) {
+		rc := newTestRedisCluster("1Gi", "1Gi", "1Gi")
+		rc.Spec.Image = "redis:5.0.6"
+		rc.Spec.Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")}
+		rc.Spec.Persistence.Mode = PersistenceModeAOF
+		rc.Default()
+
+		require.Equal(t, "redis:5.0.6", rc.Spec.Image)
+		require.Empty(t, rc.Spec.Resources.Requests)
+		require.Equal(t, PersistenceModeAOF, rc.Spec.Persistence.Mode)
+	})
+}
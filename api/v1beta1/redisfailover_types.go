@@ -0,0 +1,105 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FailoverOption determines how much of the cluster must agree to a failover
+type FailoverOption string
+
+const (
+	// FailoverOptionForce promotes the replica without the agreement of its master, e.g.
+	// when the master is unreachable
+	FailoverOptionForce FailoverOption = "Force"
+	// FailoverOptionTakeover promotes the replica without the agreement of its master or
+	// the rest of the cluster, e.g. when a majority of masters is unreachable
+	FailoverOptionTakeover FailoverOption = "Takeover"
+)
+
+// RedisFailoverSpec defines the desired state of RedisFailover
+type RedisFailoverSpec struct {
+	// ClusterName is the name of the RedisCluster, in the same namespace, to fail over
+	// +kubebuilder:validation:MinLength=1
+	ClusterName string `json:"clusterName"`
+
+	// NodeName is the name of the replica to promote to master
+	// +kubebuilder:validation:MinLength=1
+	NodeName string `json:"nodeName"`
+
+	// Option relaxes the agreement required for the failover. The replica's master must
+	// agree when omitted, so that no writes are lost.
+	// +kubebuilder:validation:Enum=Force;Takeover
+	Option FailoverOption `json:"option,omitempty"`
+}
+
+// RedisFailoverPhase is the stage a failover has reached
+type RedisFailoverPhase string
+
+const (
+	// RedisFailoverRunning means the replica has been asked to fail over its master
+	RedisFailoverRunning RedisFailoverPhase = "Running"
+	// RedisFailoverSucceeded means the replica has been promoted to master
+	RedisFailoverSucceeded RedisFailoverPhase = "Succeeded"
+	// RedisFailoverFailed means the replica could not be promoted
+	RedisFailoverFailed RedisFailoverPhase = "Failed"
+)
+
+// RedisFailoverStatus defines the observed state of RedisFailover
+type RedisFailoverStatus struct {
+	// Phase is the stage the failover has reached. A failover that hasn't started has no phase.
+	Phase RedisFailoverPhase `json:"phase,omitempty"`
+	// PreviousMasterName is the name of the node the replica followed before the failover
+	PreviousMasterName string `json:"previousMasterName,omitempty"`
+	// StartTime is when the replica was asked to fail over its master
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is when the failover succeeded or failed
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Reason is a one word, CamelCase reason for the failover's phase
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable description of the failover's outcome
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.spec.clusterName`
+// +kubebuilder:printcolumn:name="Node",type=string,JSONPath=`.spec.nodeName`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+
+// RedisFailover is the Schema for the redisfailovers API. It promotes a replica of a
+// RedisCluster to master, e.g. ahead of planned maintenance of its master's k8s node.
+type RedisFailover struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RedisFailoverSpec   `json:"spec,omitempty"`
+	Status RedisFailoverStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// RedisFailoverList contains a list of RedisFailover
+type RedisFailoverList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RedisFailover `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RedisFailover{}, &RedisFailoverList{})
+}
@@ -0,0 +1,97 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RedisUserSpec defines the desired state of RedisUser
+type RedisUserSpec struct {
+	// ClusterName is the name of the RedisCluster, in the same namespace, the user is created in
+	// +kubebuilder:validation:MinLength=1
+	ClusterName string `json:"clusterName"`
+
+	// Username is the name of the ACL user. Defaults to the name of the RedisUser.
+	// +optional
+	Username string `json:"username,omitempty"`
+
+	// PasswordSecret selects the key of a Secret, in the same namespace, holding the user's
+	// password
+	PasswordSecret corev1.SecretKeySelector `json:"passwordSecret"`
+
+	// Rules are the ACL rules granting the user access to commands and keys, e.g. "+@read"
+	// or "~cache:*". The user has no permissions when omitted. Passwords are set through
+	// passwordSecret, so rules that set or remove passwords aren't allowed.
+	// +optional
+	Rules []string `json:"rules,omitempty"`
+}
+
+// RedisUserPhase is the stage the rollout of a user has reached
+type RedisUserPhase string
+
+const (
+	// RedisUserPending means the user is yet to be applied to every node of the cluster
+	RedisUserPending RedisUserPhase = "Pending"
+	// RedisUserSynced means every node of the cluster has the user
+	RedisUserSynced RedisUserPhase = "Synced"
+	// RedisUserFailed means the user can't be applied until its spec is fixed
+	RedisUserFailed RedisUserPhase = "Failed"
+)
+
+// RedisUserStatus defines the observed state of RedisUser
+type RedisUserStatus struct {
+	// Phase is the stage the rollout of the user has reached
+	Phase RedisUserPhase `json:"phase,omitempty"`
+	// ObservedGeneration is the generation of the spec the phase applies to
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// SyncedNodes is the number of nodes of the cluster the user was last applied to
+	SyncedNodes int32 `json:"syncedNodes,omitempty"`
+	// Reason is a one word, CamelCase reason for the user's phase
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable description of the user's phase
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.spec.clusterName`
+// +kubebuilder:printcolumn:name="Username",type=string,JSONPath=`.spec.username`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+
+// RedisUser is the Schema for the redisusers API. It declares an ACL user of a RedisCluster,
+// which the controller keeps in sync on every node.
+type RedisUser struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RedisUserSpec   `json:"spec,omitempty"`
+	Status RedisUserStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// RedisUserList contains a list of RedisUser
+type RedisUserList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RedisUser `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RedisUser{}, &RedisUserList{})
+}
@@ -1,6 +1,11 @@
 package controllers
 
-import "k8s.io/apimachinery/pkg/runtime"
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
 
 // Action defines an interface for performing an arbitrary action
 type Action interface {
@@ -13,3 +18,29 @@ type Action interface {
 type ActionIdentifier interface {
 	IdentifyAction(runtime.Object) (Action, error)
 }
+
+// Observer defines an interface for refreshing the status of a k8s resource from the
+// current state of the resources it owns, so that an ActionIdentifier compares the spec
+// against what really exists rather than what was last recorded.
+type Observer interface {
+	Observe(runtime.Object) error
+}
+
+// RequeueError is returned by an Action that has made progress but cannot complete until
+// some external state has converged, e.g. a pod becoming ready. The reconciler should
+// requeue the resource after the given delay rather than treating this as a failure.
+type RequeueError struct {
+	After  time.Duration
+	Reason string
+}
+
+func (e *RequeueError) Error() string {
+	return fmt.Sprintf("requeue after %s: %s", e.After, e.Reason)
+}
+
+func requeueAfter(after time.Duration, format string, args ...interface{}) error {
+	return &RequeueError{
+		After:  after,
+		Reason: fmt.Sprintf(format, args...),
+	}
+}
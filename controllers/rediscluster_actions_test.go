@@ -0,0 +1,581 @@
+// +build unit
+
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// newFakeK8sClient returns a client backed by an in-memory store holding the given objects.
+// The fake client decodes through the client-go scheme, so the API types are added to it.
+func newFakeK8sClient(t *testing.T, objs ...runtime.Object) client.Client {
+	require.NoError(t, dbv1beta1.AddToScheme(scheme.Scheme))
+
+	return &namedFakeClient{fake.NewFakeClientWithScheme(scheme.Scheme, objs...)}
+}
+
+// namedFakeClient rejects deletes of unnamed objects as the api server does, which the fake
+// client reports as not found
+type namedFakeClient struct {
+	client.Client
+}
+
+func (c *namedFakeClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOptionFunc) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	if accessor.GetName() == "" {
+		return fmt.Errorf("resource name may not be empty")
+	}
+
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func newTestActionRedisCluster(nodes ...dbv1beta1.RedisNodeStatus) *dbv1beta1.RedisCluster {
+	redisCluster := newShardedTestRedisCluster(nodes...)
+	redisCluster.Name = "test"
+	redisCluster.Namespace = "default"
+	redisCluster.UID = "uid"
+
+	return redisCluster
+}
+
+func requireNotFound(t *testing.T, k8sClient client.Client, name string, obj runtime.Object) {
+	err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: "default"}, obj)
+	require.True(t, k8serrors.IsNotFound(err), name)
+}
+
+// fakeRedisNode is a redis node listening on the redis port of a loopback address. It
+// reports the given id and CLUSTER NODES output, holds no keys and accepts every other
+// command, recording each command it's sent.
+type fakeRedisNode struct {
+	id       string
+	nodes    string
+	listener net.Listener
+
+	mu       sync.Mutex
+	commands []string
+}
+
+// newFakeRedisNode starts a fake redis node, skipping the test if the loopback address can't
+// be listened on, e.g. as only 127.0.0.1 is configured
+func newFakeRedisNode(t *testing.T, ip, id, nodes string) *fakeRedisNode {
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, strconv.Itoa(redisPort)))
+	if err != nil {
+		t.Skipf("unable to listen on %s: %s", ip, err)
+	}
+
+	node := &fakeRedisNode{id: id, nodes: nodes, listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go node.serve(conn)
+		}
+	}()
+
+	return node
+}
+
+func (n *fakeRedisNode) Close() error {
+	return n.listener.Close()
+}
+
+func (n *fakeRedisNode) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readFakeRedisCommand(r)
+		if err != nil {
+			return
+		}
+
+		command := strings.Join(args, " ")
+		n.mu.Lock()
+		n.commands = append(n.commands, command)
+		n.mu.Unlock()
+
+		var reply string
+		switch {
+		case command == "CLUSTER MYID":
+			reply = fmt.Sprintf("$%d\r\n%s\r\n", len(n.id), n.id)
+		case command == "CLUSTER NODES":
+			reply = fmt.Sprintf("$%d\r\n%s\r\n", len(n.nodes), n.nodes)
+		case strings.HasPrefix(command, "CLUSTER GETKEYSINSLOT"):
+			reply = "*0\r\n"
+		default:
+			reply = "+OK\r\n"
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// sent returns the commands the node has been sent, with their arguments separated by spaces
+func (n *fakeRedisNode) sent() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]string{}, n.commands...)
+}
+
+// readFakeRedisCommand reads a command sent as an array of bulk strings
+func readFakeRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, count)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil { // the length of the bulk string
+			return nil, err
+		}
+
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+
+	return args, nil
+}
+
+func TestReplaceRedisNodeExecute(t *testing.T) {
+	t.Run("pod already gone", func(t *testing.T) {
+		redisCluster := newTestActionRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", IP: "10.0.0.1", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+		)
+		redisCluster.Status.Nodes[1].Ready = false
+		redisCluster.Status.Nodes[1].Failed = true
+		pvc := newRedisNodePVC(redisCluster, dbv1beta1.RedisNodeSpec{Name: "b", DiskSize: resource.MustParse("1Gi")})
+		k8sClient := newFakeK8sClient(t, pvc)
+
+		action := &ReplaceRedisNode{redisCluster: redisCluster, nodeName: "b", k8sClient: k8sClient, log: zap.Logger(true)}
+
+		// the replacement is left to AddRedisNode once the node's resources are gone
+		err := action.Execute()
+		require.IsType(t, &RequeueError{}, err)
+		requireNotFound(t, k8sClient, pvc.Name, &corev1.PersistentVolumeClaim{})
+	})
+
+	t.Run("pod recently unready", func(t *testing.T) {
+		redisCluster := newTestActionRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", IP: "10.0.0.1", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+		)
+		pod := newRedisNodePod(redisCluster, dbv1beta1.RedisNodeSpec{Name: "b"})
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse, LastTransitionTime: metav1.Now()}}
+		k8sClient := newFakeK8sClient(t, pod)
+
+		action := &ReplaceRedisNode{redisCluster: redisCluster, nodeName: "b", k8sClient: k8sClient, log: zap.Logger(true)}
+
+		err := action.Execute()
+		require.IsType(t, &RequeueError{}, err)
+		require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: "default"}, &corev1.Pod{}))
+	})
+}
+
+func TestRemoveRedisNodeExecute(t *testing.T) {
+	t.Run("departing node not observed", func(t *testing.T) {
+		redisCluster := newTestActionRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", IP: "10.0.0.1", Role: dbv1beta1.RedisNodeRoleMaster},
+		)
+		pod := newRedisNodePod(redisCluster, dbv1beta1.RedisNodeSpec{Name: "b"})
+		k8sClient := newFakeK8sClient(t, pod)
+
+		action := &RemoveRedisNode{redisCluster: redisCluster, nodeName: "b", k8sClient: k8sClient, log: zap.Logger(true)}
+
+		err := action.Execute()
+		require.IsType(t, &RequeueError{}, err)
+		require.Contains(t, err.Error(), "observed")
+		require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: "default"}, &corev1.Pod{}))
+	})
+
+	t.Run("departing node recently unready", func(t *testing.T) {
+		redisCluster := newTestActionRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", IP: "10.0.0.1", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "b", IP: "10.0.0.2", NodeID: "b-id", Role: dbv1beta1.RedisNodeRoleMaster},
+		)
+		redisCluster.Status.Nodes[1].Ready = false
+		pod := newRedisNodePod(redisCluster, dbv1beta1.RedisNodeSpec{Name: "b"})
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse, LastTransitionTime: metav1.Now()}}
+		k8sClient := newFakeK8sClient(t, pod)
+
+		action := &RemoveRedisNode{redisCluster: redisCluster, nodeName: "b", k8sClient: k8sClient, log: zap.Logger(true)}
+
+		err := action.Execute()
+		require.IsType(t, &RequeueError{}, err)
+		require.Contains(t, err.Error(), "ready")
+		require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: "default"}, &corev1.Pod{}))
+	})
+
+	t.Run("departing node that never joined the cluster", func(t *testing.T) {
+		redisCluster := newTestActionRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", IP: "10.0.0.1", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleMaster},
+		)
+		pod := newRedisNodePod(redisCluster, dbv1beta1.RedisNodeSpec{Name: "b"})
+		k8sClient := newFakeK8sClient(t, pod)
+
+		action := &RemoveRedisNode{redisCluster: redisCluster, nodeName: "b", k8sClient: k8sClient, log: zap.Logger(true)}
+
+		err := action.Execute()
+		require.IsType(t, &RequeueError{}, err)
+		require.Contains(t, err.Error(), "terminate")
+		requireNotFound(t, k8sClient, pod.Name, &corev1.Pod{})
+	})
+
+	t.Run("unreachable departing node without healthy survivors", func(t *testing.T) {
+		redisCluster := newTestActionRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", IP: "10.0.0.1", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "b", IP: "10.0.0.2", NodeID: "b-id", Role: dbv1beta1.RedisNodeRoleMaster},
+		)
+		redisCluster.Status.Nodes[0].Ready = false
+		redisCluster.Status.Nodes[1].Ready = false
+		k8sClient := newFakeK8sClient(t)
+
+		action := &RemoveRedisNode{redisCluster: redisCluster, nodeName: "b", k8sClient: k8sClient, log: zap.Logger(true)}
+
+		err := action.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "no healthy nodes remain")
+	})
+
+	// the departing node b serves four slots, which are migrated to the only other master a
+	clusterNodes := "a 127.0.0.2:6379@16379 master - 0 0 1 connected 0-8191\n" +
+		"b 127.0.0.3:6379@16379 myself,master - 0 0 2 connected 8192-8195\n" +
+		"c 127.0.0.4:6379@16379 slave a 0 0 1 connected\n"
+
+	t.Run("slots migrated", func(t *testing.T) {
+		survivor := newFakeRedisNode(t, "127.0.0.2", "a", clusterNodes)
+		defer survivor.Close()
+		replica := newFakeRedisNode(t, "127.0.0.4", "c", clusterNodes)
+		defer replica.Close()
+		departing := newFakeRedisNode(t, "127.0.0.3", "b", clusterNodes)
+		defer departing.Close()
+
+		redisCluster := newTestActionRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", IP: "127.0.0.2", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "b", IP: "127.0.0.3", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "c", IP: "127.0.0.4", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+		)
+		pod := newRedisNodePod(redisCluster, dbv1beta1.RedisNodeSpec{Name: "b"})
+		redisCluster.Spec.PVCRetentionPolicy = dbv1beta1.PVCRetentionPolicyDelete
+		pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: redisNodePVCName(redisCluster, "b"), Namespace: "default"}}
+		k8sClient := newFakeK8sClient(t, redisCluster.DeepCopy(), pod, pvc)
+
+		action := &RemoveRedisNode{redisCluster: redisCluster, nodeName: "b", k8sClient: k8sClient, log: zap.Logger(true)}
+
+		err := action.Execute()
+		require.IsType(t, &RequeueError{}, err)
+		require.Contains(t, err.Error(), "terminate")
+
+		for slot := 8192; slot <= 8195; slot++ {
+			require.Contains(t, survivor.sent(), fmt.Sprintf("CLUSTER SETSLOT %d IMPORTING b", slot))
+			require.Contains(t, departing.sent(), fmt.Sprintf("CLUSTER SETSLOT %d MIGRATING a", slot))
+			require.Contains(t, survivor.sent(), fmt.Sprintf("CLUSTER SETSLOT %d NODE a", slot))
+			require.Contains(t, departing.sent(), fmt.Sprintf("CLUSTER SETSLOT %d NODE a", slot))
+		}
+		require.Contains(t, survivor.sent(), "CLUSTER FORGET b")
+		require.Contains(t, replica.sent(), "CLUSTER FORGET b")
+
+		requireNotFound(t, k8sClient, pod.Name, &corev1.Pod{})
+		requireNotFound(t, k8sClient, pvc.Name, &corev1.PersistentVolumeClaim{})
+	})
+
+	t.Run("replicas moved", func(t *testing.T) {
+		nodes := clusterNodes + "d 127.0.0.5:6379@16379 slave b 0 0 2 connected\n"
+		survivor := newFakeRedisNode(t, "127.0.0.2", "a", nodes)
+		defer survivor.Close()
+		departing := newFakeRedisNode(t, "127.0.0.3", "b", nodes)
+		defer departing.Close()
+		replica := newFakeRedisNode(t, "127.0.0.5", "d", nodes)
+		defer replica.Close()
+
+		redisCluster := newTestActionRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", IP: "127.0.0.2", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "b", IP: "127.0.0.3", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "d", IP: "127.0.0.5", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "b"},
+		)
+		pod := newRedisNodePod(redisCluster, dbv1beta1.RedisNodeSpec{Name: "b"})
+		k8sClient := newFakeK8sClient(t, pod)
+
+		action := &RemoveRedisNode{redisCluster: redisCluster, nodeName: "b", k8sClient: k8sClient, log: zap.Logger(true)}
+
+		err := action.Execute()
+		require.IsType(t, &RequeueError{}, err)
+		require.Contains(t, err.Error(), "follow another master")
+		require.Contains(t, replica.sent(), "CLUSTER REPLICATE a")
+		require.NotContains(t, survivor.sent(), "CLUSTER FORGET b")
+		require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: "default"}, &corev1.Pod{}))
+	})
+
+	t.Run("unreachable departing node serving slots", func(t *testing.T) {
+		survivor := newFakeRedisNode(t, "127.0.0.2", "a", "a 127.0.0.2:6379@16379 myself,master - 0 0 1 connected 0-8191\n"+
+			"b 127.0.0.3:6379@16379 master,fail - 0 0 2 connected 8192-8195\n")
+		defer survivor.Close()
+
+		redisCluster := newTestActionRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", IP: "127.0.0.2", NodeID: "a", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "b", NodeID: "b", Role: dbv1beta1.RedisNodeRoleMaster},
+		)
+		redisCluster.Status.Nodes[1].Ready = false
+		k8sClient := newFakeK8sClient(t)
+
+		action := &RemoveRedisNode{redisCluster: redisCluster, nodeName: "b", k8sClient: k8sClient, log: zap.Logger(true)}
+
+		err := action.Execute()
+		require.IsType(t, &RequeueError{}, err)
+		require.Contains(t, survivor.sent(), "CLUSTER FORGET b")
+		require.Contains(t, survivor.sent(), "CLUSTER ADDSLOTS 8192 8193 8194 8195")
+	})
+
+	t.Run("no masters remain", func(t *testing.T) {
+		departing := newFakeRedisNode(t, "127.0.0.3", "b", "b 127.0.0.3:6379@16379 myself,master - 0 0 2 connected 0-16383\n")
+		defer departing.Close()
+
+		redisCluster := newTestActionRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "b", IP: "127.0.0.3", Role: dbv1beta1.RedisNodeRoleMaster},
+		)
+		pod := newRedisNodePod(redisCluster, dbv1beta1.RedisNodeSpec{Name: "b"})
+		k8sClient := newFakeK8sClient(t, redisCluster.DeepCopy(), pod)
+
+		action := &RemoveRedisNode{redisCluster: redisCluster, nodeName: "b", k8sClient: k8sClient, log: zap.Logger(true)}
+
+		err := action.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "no masters remain")
+		require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: "default"}, &corev1.Pod{}))
+	})
+}
+
+func TestAddRedisNodeExecute(t *testing.T) {
+	t.Run("missing nodes", func(t *testing.T) {
+		redisCluster := newTestActionRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+		)
+		existing := newRedisNodePod(redisCluster, dbv1beta1.RedisNodeSpec{Name: "a"})
+		k8sClient := newFakeK8sClient(t, existing)
+
+		action := &AddRedisNode{redisCluster: redisCluster, k8sClient: k8sClient, log: zap.Logger(true)}
+
+		err := action.Execute()
+		require.IsType(t, &RequeueError{}, err)
+		require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: redisConfigMapName(redisCluster), Namespace: "default"}, &corev1.ConfigMap{}))
+		require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: redisAuthSecretName(redisCluster), Namespace: "default"}, &corev1.Secret{}))
+
+		pod := &corev1.Pod{}
+		require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: redisNodePodName(redisCluster, "b"), Namespace: "default"}, pod))
+		require.Contains(t, pod.Annotations, redisAuthHashAnnotation)
+
+		pvc := &corev1.PersistentVolumeClaim{}
+		require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: redisNodePVCName(redisCluster, "b"), Namespace: "default"}, pvc))
+		require.Equal(t, resource.MustParse("1Gi"), pvc.Spec.Resources.Requests[corev1.ResourceStorage])
+
+		// nodes whose pod exists are left alone
+		requireNotFound(t, k8sClient, redisNodePVCName(redisCluster, "a"), &corev1.PersistentVolumeClaim{})
+	})
+
+	t.Run("no persistence", func(t *testing.T) {
+		redisCluster := newTestActionRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster},
+		)
+		redisCluster.Spec.Persistence.Mode = dbv1beta1.PersistenceModeNone
+		k8sClient := newFakeK8sClient(t)
+
+		action := &AddRedisNode{redisCluster: redisCluster, k8sClient: k8sClient, log: zap.Logger(true)}
+
+		err := action.Execute()
+		require.IsType(t, &RequeueError{}, err)
+		require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: redisNodePodName(redisCluster, "a"), Namespace: "default"}, &corev1.Pod{}))
+		requireNotFound(t, k8sClient, redisNodePVCName(redisCluster, "a"), &corev1.PersistentVolumeClaim{})
+	})
+
+	t.Run("pvc of a replaced node terminating", func(t *testing.T) {
+		redisCluster := newTestActionRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster},
+		)
+		pvc := newRedisNodePVC(redisCluster, dbv1beta1.RedisNodeSpec{Name: "a", DiskSize: resource.MustParse("1Gi")})
+		now := metav1.Now()
+		pvc.DeletionTimestamp = &now
+		k8sClient := newFakeK8sClient(t, pvc)
+
+		action := &AddRedisNode{redisCluster: redisCluster, k8sClient: k8sClient, log: zap.Logger(true)}
+
+		err := action.Execute()
+		require.IsType(t, &RequeueError{}, err)
+		requireNotFound(t, k8sClient, redisNodePodName(redisCluster, "a"), &corev1.Pod{})
+	})
+}
+
+func TestUpdateRedisNodeDiskSizeExecute(t *testing.T) {
+	storageClassName := "standard"
+	newAction := func(allowVolumeExpansion bool, mutate func(*corev1.PersistentVolumeClaim)) (*UpdateRedisNodeDiskSize, client.Client) {
+		redisCluster := newTestActionRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster},
+		)
+		redisCluster.Spec.StorageClassName = &storageClassName
+
+		pvc := newRedisNodePVC(redisCluster, redisCluster.Spec.Nodes[0])
+		pvc.Status.Capacity = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")}
+		redisCluster.Spec.Nodes[0].DiskSize = resource.MustParse("2Gi")
+		if mutate != nil {
+			mutate(pvc)
+		}
+
+		storageClass := &storagev1.StorageClass{AllowVolumeExpansion: &allowVolumeExpansion}
+		storageClass.Name = storageClassName
+		k8sClient := newFakeK8sClient(t, redisCluster.DeepCopy(), pvc, storageClass)
+
+		return &UpdateRedisNodeDiskSize{redisCluster: redisCluster, nodeIndex: 0, k8sClient: k8sClient, log: zap.Logger(true)}, k8sClient
+	}
+
+	getPVC := func(t *testing.T, k8sClient client.Client) *corev1.PersistentVolumeClaim {
+		pvc := &corev1.PersistentVolumeClaim{}
+		require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test-a-data", Namespace: "default"}, pvc))
+		return pvc
+	}
+
+	t.Run("expansion requested", func(t *testing.T) {
+		action, k8sClient := newAction(true, nil)
+
+		err := action.Execute()
+		require.IsType(t, &RequeueError{}, err)
+		require.Equal(t, resource.MustParse("2Gi"), getPVC(t, k8sClient).Spec.Resources.Requests[corev1.ResourceStorage])
+	})
+
+	t.Run("expansion not allowed", func(t *testing.T) {
+		action, k8sClient := newAction(false, nil)
+
+		err := action.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "does not allow volume expansion")
+		require.Equal(t, resource.MustParse("1Gi"), getPVC(t, k8sClient).Spec.Resources.Requests[corev1.ResourceStorage])
+	})
+
+	t.Run("shrink", func(t *testing.T) {
+		action, _ := newAction(true, func(pvc *corev1.PersistentVolumeClaim) {
+			pvc.Spec.Resources.Requests[corev1.ResourceStorage] = resource.MustParse("4Gi")
+		})
+
+		err := action.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "cannot be shrunk")
+	})
+
+	t.Run("filesystem resize pending", func(t *testing.T) {
+		action, _ := newAction(true, func(pvc *corev1.PersistentVolumeClaim) {
+			pvc.Spec.Resources.Requests[corev1.ResourceStorage] = resource.MustParse("2Gi")
+			pvc.Status.Capacity[corev1.ResourceStorage] = resource.MustParse("2Gi")
+			pvc.Status.Conditions = []corev1.PersistentVolumeClaimCondition{{Type: corev1.PersistentVolumeClaimFileSystemResizePending, Status: corev1.ConditionTrue}}
+		})
+
+		err := action.Execute()
+		require.IsType(t, &RequeueError{}, err)
+	})
+
+	t.Run("resized", func(t *testing.T) {
+		action, k8sClient := newAction(true, func(pvc *corev1.PersistentVolumeClaim) {
+			pvc.Spec.Resources.Requests[corev1.ResourceStorage] = resource.MustParse("2Gi")
+			pvc.Status.Capacity[corev1.ResourceStorage] = resource.MustParse("2Gi")
+		})
+
+		require.NoError(t, action.Execute())
+
+		redisCluster := &dbv1beta1.RedisCluster{}
+		require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test", Namespace: "default"}, redisCluster))
+		require.Equal(t, resource.MustParse("2Gi"), redisCluster.Status.Nodes[0].DiskSize)
+	})
+
+	t.Run("pvc not found", func(t *testing.T) {
+		redisCluster := newTestActionRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster},
+		)
+		action := &UpdateRedisNodeDiskSize{redisCluster: redisCluster, nodeIndex: 0, k8sClient: newFakeK8sClient(t), log: zap.Logger(true)}
+
+		err := action.Execute()
+		require.True(t, k8serrors.IsNotFound(err))
+	})
+}
+
+func TestRemoveRedisNodeDeleteRedisNode(t *testing.T) {
+	newAction := func(policy dbv1beta1.PVCRetentionPolicy, objs ...runtime.Object) (*RemoveRedisNode, client.Client) {
+		redisCluster := newTestActionRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", IP: "10.0.0.1", Role: dbv1beta1.RedisNodeRoleMaster},
+		)
+		redisCluster.Spec.PVCRetentionPolicy = policy
+		k8sClient := newFakeK8sClient(t, objs...)
+
+		return &RemoveRedisNode{redisCluster: redisCluster, nodeName: "a", k8sClient: k8sClient, log: zap.Logger(true)}, k8sClient
+	}
+
+	redisCluster := newTestActionRedisCluster()
+	newPod := func() *corev1.Pod { return newRedisNodePod(redisCluster, dbv1beta1.RedisNodeSpec{Name: "a"}) }
+	newPVC := func() *corev1.PersistentVolumeClaim {
+		return newRedisNodePVC(redisCluster, dbv1beta1.RedisNodeSpec{Name: "a", DiskSize: resource.MustParse("1Gi")})
+	}
+
+	t.Run("delete policy", func(t *testing.T) {
+		pod, pvc := newPod(), newPVC()
+		action, k8sClient := newAction(dbv1beta1.PVCRetentionPolicyDelete, pod, pvc)
+
+		err := action.deleteRedisNode()
+		require.IsType(t, &RequeueError{}, err)
+		requireNotFound(t, k8sClient, pod.Name, &corev1.Pod{})
+		requireNotFound(t, k8sClient, pvc.Name, &corev1.PersistentVolumeClaim{})
+	})
+
+	t.Run("retain policy", func(t *testing.T) {
+		pod, pvc := newPod(), newPVC()
+		action, k8sClient := newAction(dbv1beta1.PVCRetentionPolicyRetain, pod, pvc)
+
+		err := action.deleteRedisNode()
+		require.IsType(t, &RequeueError{}, err)
+		requireNotFound(t, k8sClient, pod.Name, &corev1.Pod{})
+		require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: pvc.Name, Namespace: "default"}, &corev1.PersistentVolumeClaim{}))
+	})
+
+	t.Run("already deleted", func(t *testing.T) {
+		action, _ := newAction(dbv1beta1.PVCRetentionPolicyDelete)
+
+		err := action.deleteRedisNode()
+		require.IsType(t, &RequeueError{}, err)
+	})
+}
@@ -0,0 +1,262 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+	"github.com/eggsbenjamin/k8s_controller_experiment/pkg/redis"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	redisAuthVolume = "auth"
+	redisAuthPath   = "/etc/redis-auth"
+	redisAuthFile   = "auth.conf"
+	// redisPasswordKey is the key of the generated password Secret, and of the auth Secret,
+	// holding the password nodes require
+	redisPasswordKey = "password"
+	// redisPreviousPasswordKey is the key of the auth Secret holding the password nodes
+	// required before the current one, which nodes yet to be reconfigured still require
+	redisPreviousPasswordKey = "previous-password"
+	// redisGeneratedPasswordBytes is the amount of randomness in a generated password
+	redisGeneratedPasswordBytes = 32
+	// redisAuthHashAnnotation identifies the password a node's pod was created with or has
+	// since had rotated to
+	redisAuthHashAnnotation = "db.k8s.io/auth-hash"
+	// redisDefaultUser is the ACL user that clients authenticating with only a password are
+	// authenticated as
+	redisDefaultUser = "default"
+)
+
+// redisAuth is how the controller connects to the nodes of a cluster: the passwords it tries,
+// in order, and the TLS configuration if nodes only accept TLS connections. A node that
+// doesn't require a password accepts the connection whichever is tried.
+type redisAuth struct {
+	passwords []string
+	tls       *tls.Config
+}
+
+// authenticate authenticates a connection with the first password the node accepts
+func (a redisAuth) authenticate(client *redis.Client) error {
+	var err error
+	for _, password := range a.passwords {
+		if err = client.Auth(password); err == nil || redis.IsNoPasswordSet(err) {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// redisClusterAuth returns how to connect to the nodes of a cluster: the passwords they may
+// currently require, the password being rolled out first, and the TLS configuration
+func redisClusterAuth(k8sClient client.Client, redisCluster *dbv1beta1.RedisCluster) (redisAuth, error) {
+	auth := redisAuth{}
+	if redisCluster.Spec.TLS != nil {
+		config, err := redisClusterTLSConfig(k8sClient, redisCluster)
+		if err != nil {
+			return auth, err
+		}
+		auth.tls = config
+	}
+
+	secret, err := getRedisAuthSecret(k8sClient, redisCluster)
+	if err != nil || secret == nil {
+		return auth, err // nodes have never required a password if there's no Secret
+	}
+
+	for _, key := range []string{redisPasswordKey, redisPreviousPasswordKey} {
+		if password := string(secret.Data[key]); password != "" {
+			auth.passwords = append(auth.passwords, password)
+		}
+	}
+
+	return auth, nil
+}
+
+// redisPasswordSecretName returns the name of the Secret holding the password a cluster's
+// nodes should require, and the key holding it
+func redisPasswordSecretName(redisCluster *dbv1beta1.RedisCluster) (string, string) {
+	if ref := redisCluster.Spec.Auth.PasswordSecret; ref != nil {
+		return ref.Name, ref.Key
+	}
+
+	return fmt.Sprintf("%s-password", redisCluster.Name), redisPasswordKey
+}
+
+// desiredRedisPassword returns the password a cluster's nodes should require, or "" if
+// clients needn't authenticate
+func desiredRedisPassword(k8sClient client.Client, redisCluster *dbv1beta1.RedisCluster) (string, error) {
+	if redisCluster.Spec.Auth == nil {
+		return "", nil
+	}
+
+	name, key := redisPasswordSecretName(redisCluster)
+	secret := &corev1.Secret{}
+	if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: redisCluster.Namespace}, secret); err != nil {
+		return "", err
+	}
+
+	password := string(secret.Data[key])
+	if password == "" {
+		return "", fmt.Errorf("password secret %s has no %s key", name, key)
+	}
+
+	return password, nil
+}
+
+// redisAuthHash identifies a password without revealing it. The hash is salted with the
+// cluster's uid so that it can't be compared across clusters.
+func redisAuthHash(redisCluster *dbv1beta1.RedisCluster, password string) string {
+	if password == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(string(redisCluster.UID)+"/"+password)))[:16]
+}
+
+// newRedisPasswordSecret returns a Secret holding a randomly generated password for a
+// cluster that doesn't reference one
+func newRedisPasswordSecret(redisCluster *dbv1beta1.RedisCluster) (*corev1.Secret, error) {
+	random := make([]byte, redisGeneratedPasswordBytes)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+
+	name, key := redisPasswordSecretName(redisCluster)
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       redisCluster.Namespace,
+			Labels:          redisClusterLabels(redisCluster),
+			OwnerReferences: []metav1.OwnerReference{redisClusterOwnerReference(redisCluster)},
+		},
+		Data: map[string][]byte{
+			key: []byte(base64.RawURLEncoding.EncodeToString(random)),
+		},
+	}, nil
+}
+
+func redisAuthSecretName(redisCluster *dbv1beta1.RedisCluster) string {
+	return fmt.Sprintf("%s-auth", redisCluster.Name)
+}
+
+// getRedisAuthSecret returns the Secret holding the passwords of a cluster's nodes, or nil if
+// it doesn't exist
+func getRedisAuthSecret(k8sClient client.Client, redisCluster *dbv1beta1.RedisCluster) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: redisAuthSecretName(redisCluster), Namespace: redisCluster.Namespace}, secret); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// renderRedisAuthConfig renders the redis.conf directives requiring clients and replicas to
+// authenticate with the given password. While a password is being rotated, clients may also
+// authenticate with the previous one.
+func renderRedisAuthConfig(password, previous string) string {
+	switch {
+	case password == "":
+		return ""
+	case previous == "" || previous == password:
+		return fmt.Sprintf("requirepass %s\nmasterauth %s\n", quoteRedisConfigValue(password), quoteRedisConfigValue(password))
+	}
+
+	// the default user is reset when it's configured in redis.conf, so its permissions are
+	// restored along with its passwords
+	return fmt.Sprintf(
+		"user %s on ~* +@all %s %s\nmasterauth %s\n",
+		redisDefaultUser,
+		quoteRedisConfigValue(">"+password),
+		quoteRedisConfigValue(">"+previous),
+		quoteRedisConfigValue(password),
+	)
+}
+
+// quoteRedisConfigValue quotes a value so that redis reads it back verbatim from redis.conf
+func quoteRedisConfigValue(value string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// applyRedisAuthSecret creates or updates the Secret holding the auth.conf included by the
+// redis.conf of a cluster's nodes, so that nodes require the given password when they
+// restart. The previous password, if any, is kept for connecting to nodes that still
+// require it and is accepted alongside the new one.
+func applyRedisAuthSecret(k8sClient client.Client, redisCluster *dbv1beta1.RedisCluster, password, previous string) error {
+	desired := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            redisAuthSecretName(redisCluster),
+			Namespace:       redisCluster.Namespace,
+			Labels:          redisClusterLabels(redisCluster),
+			OwnerReferences: []metav1.OwnerReference{redisClusterOwnerReference(redisCluster)},
+		},
+		Data: map[string][]byte{
+			redisAuthFile:    []byte(renderRedisAuthConfig(password, previous)),
+			redisPasswordKey: []byte(password),
+		},
+	}
+	if previous != "" && previous != password {
+		desired.Data[redisPreviousPasswordKey] = []byte(previous)
+	}
+
+	existing := &corev1.Secret{}
+	if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: desired.Name, Namespace: desired.Namespace}, existing); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return err
+		}
+
+		return k8sClient.Create(context.TODO(), desired)
+	}
+
+	if equality.Semantic.DeepEqual(existing.Data, desired.Data) {
+		return nil
+	}
+
+	existing.Data = desired.Data
+	return k8sClient.Update(context.TODO(), existing)
+}
+
+// rotationGracePeriod returns how long nodes accept both the old and the new password when a
+// cluster's password changes
+func rotationGracePeriod(redisCluster *dbv1beta1.RedisCluster) time.Duration {
+	if auth := redisCluster.Spec.Auth; auth != nil && auth.RotationGracePeriod != nil {
+		return auth.RotationGracePeriod.Duration
+	}
+	if redisCluster.Spec.Auth == nil {
+		return 0 // nodes that stop requiring a password accept clients that still send one
+	}
+
+	return dbv1beta1.DefaultRotationGracePeriod
+}
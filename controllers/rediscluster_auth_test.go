@@ -0,0 +1,59 @@
+// +build unit
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRenderRedisAuthConfig(t *testing.T) {
+	require.Equal(t, "", renderRedisAuthConfig("", ""))
+	require.Equal(t, "", renderRedisAuthConfig("", "old"))
+	require.Equal(t, "requirepass \"secret\"\nmasterauth \"secret\"\n", renderRedisAuthConfig("secret", ""))
+	require.Equal(t, "requirepass \"secret\"\nmasterauth \"secret\"\n", renderRedisAuthConfig("secret", "secret"))
+	require.Equal(t, `requirepass "a \"b\" \\c\x0a"`+"\n"+`masterauth "a \"b\" \\c\x0a"`+"\n", renderRedisAuthConfig("a \"b\" \\c\n", ""))
+
+	// both passwords are accepted while rotating
+	require.Equal(t, "user default on ~* +@all \">secret\" \">old\"\nmasterauth \"secret\"\n", renderRedisAuthConfig("secret", "old"))
+}
+
+func TestRotationGracePeriod(t *testing.T) {
+	redisCluster := &dbv1beta1.RedisCluster{}
+	require.Equal(t, time.Duration(0), rotationGracePeriod(redisCluster))
+
+	redisCluster.Spec.Auth = &dbv1beta1.RedisAuth{}
+	require.Equal(t, dbv1beta1.DefaultRotationGracePeriod, rotationGracePeriod(redisCluster))
+
+	redisCluster.Spec.Auth.RotationGracePeriod = &metav1.Duration{Duration: time.Minute}
+	require.Equal(t, time.Minute, rotationGracePeriod(redisCluster))
+}
+
+func TestRedisAuthHash(t *testing.T) {
+	redisCluster := &dbv1beta1.RedisCluster{ObjectMeta: metav1.ObjectMeta{UID: "1"}}
+	otherCluster := &dbv1beta1.RedisCluster{ObjectMeta: metav1.ObjectMeta{UID: "2"}}
+
+	require.Equal(t, "", redisAuthHash(redisCluster, ""))
+	require.Len(t, redisAuthHash(redisCluster, "secret"), 16)
+	require.Equal(t, redisAuthHash(redisCluster, "secret"), redisAuthHash(redisCluster, "secret"))
+	require.NotEqual(t, redisAuthHash(redisCluster, "secret"), redisAuthHash(redisCluster, "other"))
+	require.NotEqual(t, redisAuthHash(redisCluster, "secret"), redisAuthHash(otherCluster, "secret"))
+}
+
+func TestNewRedisPasswordSecret(t *testing.T) {
+	redisCluster := &dbv1beta1.RedisCluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	redisCluster.Spec.Auth = &dbv1beta1.RedisAuth{}
+
+	secret, err := newRedisPasswordSecret(redisCluster)
+	require.NoError(t, err)
+	require.Equal(t, "test-password", secret.Name)
+	require.Len(t, secret.Data[redisPasswordKey], 43)
+
+	other, err := newRedisPasswordSecret(redisCluster)
+	require.NoError(t, err)
+	require.NotEqual(t, secret.Data[redisPasswordKey], other.Data[redisPasswordKey])
+}
@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	redisConfigVolume = "config"
+	redisConfigPath   = "/etc/redis"
+	redisConfigFile   = "redis.conf"
+	// redisConfigHashAnnotation identifies the configuration a node's pod was created with or
+	// has since had applied at runtime
+	redisConfigHashAnnotation = "db.k8s.io/config-hash"
+	// redisStaticConfigHashAnnotation identifies the settings a node's pod was started with
+	// that can only be changed by restarting it
+	redisStaticConfigHashAnnotation = "db.k8s.io/static-config-hash"
+)
+
+// liveRedisConfig are the redis.conf directives that redis allows to be changed at runtime
+// with CONFIG SET. Changing any other directive requires the node to be restarted.
+var liveRedisConfig = map[string]bool{
+	"maxmemory":                       true,
+	"maxmemory-policy":                true,
+	"maxmemory-samples":               true,
+	"maxclients":                      true,
+	"timeout":                         true,
+	"tcp-keepalive":                   true,
+	"cluster-node-timeout":            true,
+	"cluster-require-full-coverage":   true,
+	"cluster-replica-validity-factor": true,
+	"cluster-migration-barrier":       true,
+	"appendonly":                      true,
+	"appendfsync":                     true,
+	"save":                            true,
+	"slowlog-log-slower-than":         true,
+	"slowlog-max-len":                 true,
+	"latency-monitor-threshold":       true,
+	"notify-keyspace-events":          true,
+	"hz":                              true,
+	"lazyfree-lazy-eviction":          true,
+	"lazyfree-lazy-expire":            true,
+	"lazyfree-lazy-server-del":        true,
+	"replica-lazy-flush":              true,
+	"hash-max-ziplist-entries":        true,
+	"hash-max-ziplist-value":          true,
+	"list-max-ziplist-size":           true,
+	"set-max-intset-entries":          true,
+	"zset-max-ziplist-entries":        true,
+	"zset-max-ziplist-value":          true,
+}
+
+// redisDirective is a single redis.conf setting
+type redisDirective struct {
+	name  string
+	value string
+}
+
+// redisConfigDirectives returns the redis.conf directives of a cluster's nodes. The settings
+// the controller relies on come first, followed by the persistence mode, the typed settings
+// of the spec and finally the overrides in name order. The tls settings, when enabled,
+// follow the settings the controller relies on.
+func redisConfigDirectives(redisCluster *dbv1beta1.RedisCluster) []redisDirective {
+	directives := []redisDirective{
+		{"port", strconv.Itoa(redisPort)},
+		{"cluster-enabled", "yes"},
+		{"cluster-config-file", redisDataPath + "/nodes.conf"},
+		{"dir", redisDataPath},
+		{"include", redisAuthPath + "/" + redisAuthFile},
+	}
+
+	// with tls the same port only accepts tls connections, and the cluster bus and
+	// replication links use tls too. Clients aren't required to present a certificate as
+	// they authenticate with a password.
+	if redisCluster.Spec.TLS != nil {
+		directives[0].value = "0"
+		directives = append(directives,
+			redisDirective{"tls-port", strconv.Itoa(redisPort)},
+			redisDirective{"tls-cluster", "yes"},
+			redisDirective{"tls-replication", "yes"},
+			redisDirective{"tls-cert-file", redisTLSPath + "/" + corev1.TLSCertKey},
+			redisDirective{"tls-key-file", redisTLSPath + "/" + corev1.TLSPrivateKeyKey},
+			redisDirective{"tls-ca-cert-file", redisTLSPath + "/" + redisCACertificateKey},
+			redisDirective{"tls-auth-clients", "no"},
+		)
+	}
+
+	// clusters stored before the schedule and fsync policy were defaulted use redis' own
+	persistence := redisCluster.Spec.Persistence
+	save := persistence.SaveSchedule
+	if save == "" {
+		save = dbv1beta1.DefaultSaveSchedule
+	}
+	appendFsync := persistence.AppendFsync
+	if appendFsync == "" {
+		appendFsync = dbv1beta1.DefaultAppendFsync
+	}
+
+	switch persistence.Mode {
+	case dbv1beta1.PersistenceModeAOF:
+		directives = append(directives, redisDirective{"appendonly", "yes"}, redisDirective{"appendfsync", appendFsync}, redisDirective{"save", ""})
+	case dbv1beta1.PersistenceModeRDB:
+		directives = append(directives, redisDirective{"appendonly", "no"}, redisDirective{"save", save})
+	case dbv1beta1.PersistenceModeNone:
+		directives = append(directives, redisDirective{"appendonly", "no"}, redisDirective{"save", ""})
+	default:
+		directives = append(directives, redisDirective{"appendonly", "yes"}, redisDirective{"appendfsync", appendFsync}, redisDirective{"save", save})
+	}
+
+	config := redisCluster.Spec.Config
+	if config.MaxMemory != nil {
+		directives = append(directives, redisDirective{"maxmemory", strconv.FormatInt(config.MaxMemory.Value(), 10)})
+	}
+	if config.MaxMemoryPolicy != "" {
+		directives = append(directives, redisDirective{"maxmemory-policy", config.MaxMemoryPolicy})
+	}
+	for _, setting := range []struct {
+		name  string
+		value *int32
+	}{
+		{"maxclients", config.MaxClients},
+		{"timeout", config.Timeout},
+		{"tcp-keepalive", config.TCPKeepAlive},
+		{"cluster-node-timeout", config.ClusterNodeTimeout},
+	} {
+		if setting.value != nil {
+			directives = append(directives, redisDirective{setting.name, strconv.Itoa(int(*setting.value))})
+		}
+	}
+
+	rendered := map[string]bool{}
+	for _, directive := range directives {
+		rendered[directive.name] = true
+	}
+
+	names := make([]string, 0, len(config.Overrides))
+	for name := range config.Overrides {
+		// overrides of managed settings are rejected by the webhook, but clusters stored
+		// before it existed mustn't be able to break the controller's assumptions
+		if !rendered[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		directives = append(directives, redisDirective{name, config.Overrides[name]})
+	}
+
+	return directives
+}
+
+// renderRedisConfig renders directives in redis.conf format. A snapshot schedule is written
+// as one save line per rule, as redis only accepts a single rule per line.
+func renderRedisConfig(directives []redisDirective) string {
+	var b strings.Builder
+	for _, directive := range directives {
+		fields := strings.Fields(directive.value)
+		switch {
+		case len(fields) == 0:
+			fmt.Fprintf(&b, "%s \"\"\n", directive.name)
+		case directive.name == "save":
+			for i := 0; i+1 < len(fields); i += 2 {
+				fmt.Fprintf(&b, "save %s %s\n", fields[i], fields[i+1])
+			}
+		default:
+			fmt.Fprintf(&b, "%s %s\n", directive.name, directive.value)
+		}
+	}
+
+	return b.String()
+}
+
+// hashRedisConfig returns a short digest of the given directives
+func hashRedisConfig(directives []redisDirective) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(renderRedisConfig(directives))))[:16]
+}
+
+// redisConfigHash returns the digest of the whole configuration of a cluster's nodes. The
+// password is rotated separately, so isn't part of it.
+func redisConfigHash(redisCluster *dbv1beta1.RedisCluster) string {
+	return hashRedisConfig(redisConfigDirectives(redisCluster))
+}
+
+// redisStaticConfigHash returns the digest of the settings of a cluster's nodes that can only
+// be changed by restarting them
+func redisStaticConfigHash(redisCluster *dbv1beta1.RedisCluster) string {
+	static := []redisDirective{}
+	for _, directive := range redisConfigDirectives(redisCluster) {
+		if !liveRedisConfig[directive.name] {
+			static = append(static, directive)
+		}
+	}
+
+	return hashRedisConfig(static)
+}
+
+func redisConfigMapName(redisCluster *dbv1beta1.RedisCluster) string {
+	return fmt.Sprintf("%s-config", redisCluster.Name)
+}
+
+func newRedisConfigMap(redisCluster *dbv1beta1.RedisCluster) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            redisConfigMapName(redisCluster),
+			Namespace:       redisCluster.Namespace,
+			Labels:          redisClusterLabels(redisCluster),
+			OwnerReferences: []metav1.OwnerReference{redisClusterOwnerReference(redisCluster)},
+		},
+		Data: map[string]string{
+			redisConfigFile: renderRedisConfig(redisConfigDirectives(redisCluster)),
+		},
+	}
+}
+
+// applyRedisConfigMap creates the ConfigMap holding the redis.conf of a cluster's nodes, or
+// updates it if it has drifted from the spec
+func applyRedisConfigMap(k8sClient client.Client, redisCluster *dbv1beta1.RedisCluster) error {
+	desired := newRedisConfigMap(redisCluster)
+
+	existing := &corev1.ConfigMap{}
+	if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: desired.Name, Namespace: desired.Namespace}, existing); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return err
+		}
+
+		return k8sClient.Create(context.TODO(), desired)
+	}
+
+	if equality.Semantic.DeepEqual(existing.Data, desired.Data) {
+		return nil
+	}
+
+	existing.Data = desired.Data
+	return k8sClient.Update(context.TODO(), existing)
+}
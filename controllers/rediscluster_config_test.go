@@ -0,0 +1,163 @@
+// +build unit
+
+package controllers
+
+import (
+	"testing"
+
+	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestRenderRedisConfig(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		redisCluster := &dbv1beta1.RedisCluster{}
+
+		require.Equal(t, `port 6379
+cluster-enabled yes
+cluster-config-file /data/nodes.conf
+dir /data
+include /etc/redis-auth/auth.conf
+appendonly yes
+appendfsync everysec
+save 900 1
+save 300 10
+save 60 10000
+`, renderRedisConfig(redisConfigDirectives(redisCluster)))
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		redisCluster := &dbv1beta1.RedisCluster{
+			Spec: dbv1beta1.RedisClusterSpec{
+				TLS: &dbv1beta1.RedisTLS{},
+				Config: dbv1beta1.RedisConfig{
+					Overrides: map[string]string{"tls-port": "6380"},
+				},
+			},
+		}
+
+		require.Equal(t, `port 0
+cluster-enabled yes
+cluster-config-file /data/nodes.conf
+dir /data
+include /etc/redis-auth/auth.conf
+tls-port 6379
+tls-cluster yes
+tls-replication yes
+tls-cert-file /etc/redis-tls/tls.crt
+tls-key-file /etc/redis-tls/tls.key
+tls-ca-cert-file /etc/redis-tls/ca.crt
+tls-auth-clients no
+appendonly yes
+appendfsync everysec
+save 900 1
+save 300 10
+save 60 10000
+`, renderRedisConfig(redisConfigDirectives(redisCluster)))
+	})
+
+	t.Run("snapshots only", func(t *testing.T) {
+		redisCluster := &dbv1beta1.RedisCluster{
+			Spec: dbv1beta1.RedisClusterSpec{
+				Persistence: dbv1beta1.RedisPersistence{Mode: dbv1beta1.PersistenceModeRDB, SaveSchedule: "3600 1", AppendFsync: "always"},
+			},
+		}
+
+		require.Equal(t, `port 6379
+cluster-enabled yes
+cluster-config-file /data/nodes.conf
+dir /data
+include /etc/redis-auth/auth.conf
+appendonly no
+save 3600 1
+`, renderRedisConfig(redisConfigDirectives(redisCluster)))
+	})
+
+	t.Run("no persistence", func(t *testing.T) {
+		redisCluster := &dbv1beta1.RedisCluster{
+			Spec: dbv1beta1.RedisClusterSpec{
+				Persistence: dbv1beta1.RedisPersistence{Mode: dbv1beta1.PersistenceModeNone, SaveSchedule: "3600 1"},
+			},
+		}
+
+		require.Equal(t, `port 6379
+cluster-enabled yes
+cluster-config-file /data/nodes.conf
+dir /data
+include /etc/redis-auth/auth.conf
+appendonly no
+save ""
+`, renderRedisConfig(redisConfigDirectives(redisCluster)))
+	})
+
+	t.Run("typed settings and overrides", func(t *testing.T) {
+		maxMemory := resource.MustParse("1Mi")
+		timeout := int32(30)
+		redisCluster := &dbv1beta1.RedisCluster{
+			Spec: dbv1beta1.RedisClusterSpec{
+				Persistence: dbv1beta1.RedisPersistence{Mode: dbv1beta1.PersistenceModeAOF, AppendFsync: "always"},
+				Config: dbv1beta1.RedisConfig{
+					MaxMemory:       &maxMemory,
+					MaxMemoryPolicy: "allkeys-lru",
+					Timeout:         &timeout,
+					Overrides: map[string]string{
+						"slowlog-max-len":         "256",
+						"notify-keyspace-events":  "",
+						"cluster-config-file":     "/tmp/nodes.conf",
+						"activerehashing":         "no",
+						"slowlog-log-slower-than": "1000",
+					},
+				},
+			},
+		}
+
+		require.Equal(t, `port 6379
+cluster-enabled yes
+cluster-config-file /data/nodes.conf
+dir /data
+include /etc/redis-auth/auth.conf
+appendonly yes
+appendfsync always
+save ""
+maxmemory 1048576
+maxmemory-policy allkeys-lru
+timeout 30
+activerehashing no
+notify-keyspace-events ""
+slowlog-log-slower-than 1000
+slowlog-max-len 256
+`, renderRedisConfig(redisConfigDirectives(redisCluster)))
+	})
+}
+
+func TestRedisConfigHash(t *testing.T) {
+	redisCluster := &dbv1beta1.RedisCluster{}
+	hash, staticHash := redisConfigHash(redisCluster), redisStaticConfigHash(redisCluster)
+
+	t.Run("live setting changed", func(t *testing.T) {
+		changed := redisCluster.DeepCopy()
+		changed.Spec.Config.MaxMemoryPolicy = "allkeys-lfu"
+		changed.Spec.Persistence.Mode = dbv1beta1.PersistenceModeRDB
+
+		require.NotEqual(t, hash, redisConfigHash(changed))
+		require.Equal(t, staticHash, redisStaticConfigHash(changed))
+	})
+
+	t.Run("password changed", func(t *testing.T) {
+		changed := redisCluster.DeepCopy()
+		changed.Status.AuthHash = "0123456789abcdef"
+
+		// passwords are rotated rather than configured
+		require.Equal(t, hash, redisConfigHash(changed))
+		require.Equal(t, staticHash, redisStaticConfigHash(changed))
+	})
+
+	t.Run("static setting changed", func(t *testing.T) {
+		changed := redisCluster.DeepCopy()
+		changed.Spec.Config.Overrides = map[string]string{"activerehashing": "no"}
+
+		require.NotEqual(t, hash, redisConfigHash(changed))
+		require.NotEqual(t, staticHash, redisStaticConfigHash(changed))
+	})
+}
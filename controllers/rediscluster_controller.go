@@ -18,13 +18,22 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+	"github.com/eggsbenjamin/k8s_controller_experiment/pkg/redis"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 )
@@ -33,6 +42,7 @@ import (
 type RedisClusterReconciler struct {
 	client.Client
 	Log              logr.Logger
+	observer         Observer
 	actionIdentifier ActionIdentifier
 }
 
@@ -45,9 +55,15 @@ type RedisClusterReconciler struct {
 
 // +kubebuilder:rbac:groups=db.k8s.io,resources=redisclusters,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=db.k8s.io,resources=redisclusters/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch
 func (r *RedisClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	_ = context.Background()
-	_ = r.Log.WithValues("rediscluster", req.NamespacedName)
+	log := r.Log.WithValues("rediscluster", req.NamespacedName)
 
 	redisCluster := &dbv1beta1.RedisCluster{}
 	if err := r.Get(context.TODO(), types.NamespacedName{Name: req.Name, Namespace: req.Namespace}, redisCluster); err != nil {
@@ -58,52 +74,1575 @@ func (r *RedisClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error
 		return ctrl.Result{}, err
 	}
 
+	original := redisCluster.Status.DeepCopy()
+
+	action, err := r.reconcile(redisCluster)
+	updateConditions(redisCluster, action, err)
+
+	if redisCluster.Status.ObservedGeneration != original.ObservedGeneration || !equality.Semantic.DeepEqual(redisCluster.Status.Conditions, original.Conditions) {
+		updateErr := updateStatus(r.Client, redisCluster, func(redisCluster *dbv1beta1.RedisCluster) {
+			updateConditions(redisCluster, action, err)
+		})
+		if updateErr != nil && !k8serrors.IsNotFound(updateErr) && err == nil {
+			err = updateErr
+		}
+	}
+
+	if requeue, ok := err.(*RequeueError); ok {
+		log.Info("action in progress", "action", actionName(action), "reason", requeue.Reason)
+		return ctrl.Result{RequeueAfter: requeue.After}, nil
+	}
+
+	return ctrl.Result{}, err
+}
+
+// reconcile refreshes the status of the RedisCluster and executes the action of highest
+// priority, returning the action taken, if any
+func (r *RedisClusterReconciler) reconcile(redisCluster *dbv1beta1.RedisCluster) (Action, error) {
+	if redisCluster.DeletionTimestamp == nil && !containsString(redisCluster.Finalizers, redisClusterFinalizer) {
+		redisCluster.Finalizers = append(redisCluster.Finalizers, redisClusterFinalizer)
+		if err := r.Update(context.TODO(), redisCluster); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.observer.Observe(redisCluster); err != nil {
+		return nil, err
+	}
+
 	action, err := r.actionIdentifier.IdentifyAction(redisCluster)
 	if err != nil {
-		return ctrl.Result{}, err
+		return nil, err
+	}
+
+	if action == nil {
+		return nil, nil // no action to take
+	}
+
+	return action, action.Execute()
+}
+
+func (r *RedisClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.observer == nil {
+		r.observer = NewRedisClusterObserver(r.Client, r.Log)
+	}
+
+	if r.actionIdentifier == nil {
+		r.actionIdentifier = NewRedisClusterActionIdentifier(r.Client, r.Log)
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dbv1beta1.RedisCluster{}).
+		Owns(&corev1.Pod{}).
+		Owns(&corev1.PersistentVolumeClaim{}).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Secret{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.passwordSecretRequests),
+		}).
+		Complete(r)
+}
+
+// passwordSecretRequests maps a Secret to the clusters whose password it holds, so that
+// changing the password is rolled out to their nodes
+func (r *RedisClusterReconciler) passwordSecretRequests(obj handler.MapObject) []reconcile.Request {
+	redisClusters := &dbv1beta1.RedisClusterList{}
+	if err := r.List(context.TODO(), redisClusters, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "unable to list redis clusters", "namespace", obj.Meta.GetNamespace())
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, redisCluster := range redisClusters.Items {
+		if redisCluster.Spec.Auth == nil {
+			continue
+		}
+
+		if name, _ := redisPasswordSecretName(&redisCluster); name == obj.Meta.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: redisCluster.Name, Namespace: redisCluster.Namespace}})
+		}
+	}
+
+	return requests
+}
+
+type GenerateRedisPassword struct {
+	redisCluster *dbv1beta1.RedisCluster
+	k8sClient    client.Client
+	log          logr.Logger
+}
+
+// Execute creates a Secret holding a random password for a cluster that requires clients to
+// authenticate but doesn't reference a password of its own
+func (a *GenerateRedisPassword) Execute() error {
+	secret, err := newRedisPasswordSecret(a.redisCluster)
+	if err != nil {
+		return err
+	}
+
+	if err := a.k8sClient.Create(context.TODO(), secret); err != nil {
+		if !k8serrors.IsAlreadyExists(err) {
+			return err
+		}
+	} else {
+		a.log.Info("generated redis password", "rediscluster", a.redisCluster.Name, "secret", secret.Name)
+	}
+
+	return requeueAfter(time.Second, "waiting for the generated password to be observed")
+}
+
+type AddRedisNode struct {
+	redisCluster *dbv1beta1.RedisCluster
+	k8sClient    client.Client
+	log          logr.Logger
+}
+
+// Execute provisions the PVC and pod of every node in the spec whose pod doesn't exist, or
+// only the pod if the cluster doesn't persist its data. Nodes are recorded in the status by
+// the observer once their pods have been created.
+func (a *AddRedisNode) Execute() error {
+	pods := &corev1.PodList{}
+	if err := a.k8sClient.List(context.TODO(), pods, client.InNamespace(a.redisCluster.Namespace), client.MatchingLabels(redisClusterLabels(a.redisCluster))); err != nil {
+		return err
+	}
+
+	existing := map[string]bool{}
+	for _, pod := range pods.Items {
+		existing[pod.Name] = true
+	}
+
+	// redis.conf includes auth.conf, so both must exist for nodes to start
+	if err := applyRedisConfigMap(a.k8sClient, a.redisCluster); err != nil {
+		return err
+	}
+	authHash, err := a.ensureAuthSecret()
+	if err != nil {
+		return err
+	}
+
+	for _, node := range a.redisCluster.Spec.DesiredNodes() {
+		if existing[redisNodePodName(a.redisCluster, node.Name)] {
+			continue
+		}
+
+		log := a.log.WithValues("rediscluster", a.redisCluster.Name, "node", node.Name)
+
+		// nodes that don't persist their data get an emptyDir instead of a PVC
+		if a.redisCluster.Spec.Persistence.Mode != dbv1beta1.PersistenceModeNone {
+			pvc := newRedisNodePVC(a.redisCluster, node)
+			if err := a.k8sClient.Create(context.TODO(), pvc); err != nil {
+				if !k8serrors.IsAlreadyExists(err) {
+					return err
+				}
+
+				// the PVC of a replaced node must be gone before the replacement is created,
+				// otherwise the pod would be bound to a PVC that is about to disappear
+				existing := &corev1.PersistentVolumeClaim{}
+				if err := a.k8sClient.Get(context.TODO(), types.NamespacedName{Name: pvc.Name, Namespace: pvc.Namespace}, existing); err != nil {
+					return err
+				}
+				if existing.DeletionTimestamp != nil {
+					log.Info("waiting for redis node pvc to be deleted", "pvc", pvc.Name)
+					continue
+				}
+			} else {
+				log.Info("created redis node pvc", "pvc", pvc.Name)
+			}
+		}
+
+		// nodes serve the certificate in their tls Secret, which is issued here unless the
+		// cluster references one
+		if a.redisCluster.Spec.TLS != nil && a.redisCluster.Spec.TLS.CertificateSecret == nil {
+			if err := applyRedisNodeCertificate(a.k8sClient, a.redisCluster, node.Name); err != nil {
+				return err
+			}
+		}
+
+		pod := newRedisNodePod(a.redisCluster, node)
+		pod.Annotations[redisAuthHashAnnotation] = authHash
+		if err := a.k8sClient.Create(context.TODO(), pod); err != nil {
+			if !k8serrors.IsAlreadyExists(err) {
+				return err
+			}
+		} else {
+			log.Info("created redis node pod", "pod", pod.Name)
+		}
+	}
+
+	return requeueAfter(time.Second, "waiting for redis node pods to be observed")
+}
+
+// ensureAuthSecret creates the auth Secret of a cluster that doesn't have one, returning the
+// hash of the password new nodes will require. A password that has changed is left for
+// RotateRedisPassword to roll out, so that new nodes require the same password as the rest.
+func (a *AddRedisNode) ensureAuthSecret() (string, error) {
+	secret, err := getRedisAuthSecret(a.k8sClient, a.redisCluster)
+	if err != nil {
+		return "", err
+	}
+	if secret != nil {
+		return redisAuthHash(a.redisCluster, string(secret.Data[redisPasswordKey])), nil
+	}
+
+	password, err := desiredRedisPassword(a.k8sClient, a.redisCluster)
+	if err != nil {
+		return "", err
+	}
+	if err := applyRedisAuthSecret(a.k8sClient, a.redisCluster, password, ""); err != nil {
+		return "", err
+	}
+
+	return redisAuthHash(a.redisCluster, password), nil
+}
+
+type RemoveRedisNode struct {
+	redisCluster *dbv1beta1.RedisCluster
+	nodeName     string
+	k8sClient    client.Client
+	log          logr.Logger
+}
+
+// Execute removes the named node from the cluster. Every hash slot it serves is migrated to
+// the remaining masters and its replicas are moved to one of them, then the survivors are told
+// to forget it before its pod is deleted. Its PVC is deleted too when the retention policy is
+// Delete. The node is dropped from the status by the observer. A node that can't be reached is
+// handed over without its data, as a failed node is when it's replaced.
+func (a *RemoveRedisNode) Execute() error {
+	log := a.log.WithValues("rediscluster", a.redisCluster.Name, "node", a.nodeName)
+
+	var departing *dbv1beta1.RedisNodeStatus
+	survivors := []dbv1beta1.RedisNodeStatus{}
+	for i, node := range a.redisCluster.Status.Nodes {
+		if statusNodeName(i, node) == a.nodeName {
+			departing = &a.redisCluster.Status.Nodes[i]
+		} else {
+			survivors = append(survivors, node)
+		}
+	}
+
+	if departing == nil {
+		return requeueAfter(5*time.Second, "waiting for node %s to be observed before removing it", a.nodeName)
+	}
+
+	// slots can only be migrated off a node that can be reached
+	if departing.IP == "" || !departing.Ready {
+		return a.removeUnreachableRedisNode(*departing, survivors)
+	}
+
+	auth, err := redisClusterAuth(a.k8sClient, a.redisCluster)
+	if err != nil {
+		return err
+	}
+
+	source, err := dialRedisNode(auth, departing.IP)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	departingID, err := source.ClusterMyID()
+	if err != nil {
+		return err
+	}
+
+	clusterNodes, err := source.ClusterNodes()
+	if err != nil {
+		return err
+	}
+
+	slots := []int{}
+	masters := []redis.ClusterNode{}
+	replicas := []redis.ClusterNode{}
+	for _, node := range clusterNodes {
+		if node.ID == departingID {
+			slots = node.SlotList()
+			continue
+		}
+
+		if node.HasFlag("master") && !node.HasFlag("fail") {
+			masters = append(masters, node)
+		}
+
+		if node.MasterID == departingID && !node.HasFlag("fail") {
+			replicas = append(replicas, node)
+		}
+	}
+
+	// slots go to masters that already serve slots rather than to nodes due to become replicas
+	serving := []redis.ClusterNode{}
+	for _, master := range masters {
+		if master.SlotCount() > 0 {
+			serving = append(serving, master)
+		}
+	}
+	if len(serving) > 0 {
+		masters = serving
+	}
+
+	if len(slots) > 0 && len(masters) == 0 {
+		return fmt.Errorf("unable to remove node %s: no masters remain to take ownership of its %d slots", a.nodeName, len(slots))
+	}
+
+	if len(replicas) > 0 && len(masters) == 0 {
+		return fmt.Errorf("unable to remove node %s: no masters remain for its %d replicas to follow", a.nodeName, len(replicas))
+	}
+
+	destinations := make([]*redis.Client, len(masters))
+	for i, master := range masters {
+		if destinations[i], err = dialRedisNode(auth, master.IP); err != nil {
+			return err
+		}
+		defer destinations[i].Close()
+	}
+
+	for i, slot := range slots {
+		// hand out contiguous runs of slots so ownership stays in as few ranges as possible
+		j := i * len(masters) / len(slots)
+		if err := migrateSlot(source, destinations[j], departingID, masters[j].ID, masters[j].IP, slot); err != nil {
+			return fmt.Errorf("unable to migrate slot %d to %s: %s", slot, masters[j].ID, err)
+		}
+	}
+	log.Info("migrated slots off redis node", "slots", len(slots))
+
+	// replicas refuse to forget their own master, so they're moved to another one first
+	for i, replica := range replicas {
+		master := masters[i%len(masters)]
+		if err := replicateRedisNode(auth, replica.IP, master.ID); err != nil {
+			return err
+		}
+		log.Info("moved replica off redis node", "replica", replica.ID, "master", master.ID)
+	}
+	if len(replicas) > 0 {
+		return requeueAfter(2*time.Second, "waiting for replicas of node %s to follow another master", a.nodeName)
+	}
+
+	for _, survivor := range survivors {
+		if survivor.IP == "" {
+			continue // a node without an ip has no cluster state to forget the departing node from
+		}
+
+		if err := forgetRedisNode(auth, survivor.IP, departingID); err != nil {
+			return err
+		}
+	}
+
+	return a.deleteRedisNode()
+}
+
+// removeUnreachableRedisNode removes a node that can't be reached to migrate its slots off,
+// e.g. because it's crash looping. Once it has been unready for redisNodeReplacementDelay its
+// slots are taken over as when a failed node is replaced, its replicas are moved to another
+// master and the survivors are told to forget it before its resources are deleted.
+func (a *RemoveRedisNode) removeUnreachableRedisNode(departing dbv1beta1.RedisNodeStatus, survivors []dbv1beta1.RedisNodeStatus) error {
+	log := a.log.WithValues("rediscluster", a.redisCluster.Name, "node", a.nodeName)
+
+	pod := &corev1.Pod{}
+	if err := a.k8sClient.Get(context.TODO(), types.NamespacedName{Name: redisNodePodName(a.redisCluster, a.nodeName), Namespace: a.redisCluster.Namespace}, pod); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return err
+		}
+	} else if wait := redisNodeReplacementDelay - time.Since(podUnreadySince(pod)); wait > 0 {
+		return requeueAfter(wait, "waiting %s for node %s to be ready before removing it", wait.Round(time.Second), a.nodeName)
+	}
+
+	// a node that never joined the cluster has nothing to hand over
+	if departing.NodeID == "" {
+		return a.deleteRedisNode()
+	}
+
+	healthy := []dbv1beta1.RedisNodeStatus{}
+	for _, survivor := range survivors {
+		if survivor.IP != "" && survivor.Ready && !survivor.Failed {
+			healthy = append(healthy, survivor)
+		}
+	}
+	if len(healthy) == 0 {
+		return fmt.Errorf("unable to remove node %s: no healthy nodes remain", a.nodeName)
+	}
+
+	auth, err := redisClusterAuth(a.k8sClient, a.redisCluster)
+	if err != nil {
+		return err
+	}
+
+	_, clusterNodes, err := observeRedisNode(auth, healthy[0].IP)
+	if err != nil {
+		return err
+	}
+
+	masters := []redis.ClusterNode{}
+	replicas := []redis.ClusterNode{}
+	for i, node := range clusterNodes {
+		if node.ID == departing.NodeID {
+			if node.SlotCount() > 0 {
+				replace := &ReplaceRedisNode{redisCluster: a.redisCluster, nodeName: a.nodeName, k8sClient: a.k8sClient, log: a.log}
+				return replace.takeOver(auth, clusterNodes[i], clusterNodes, healthy)
+			}
+			continue
+		}
+
+		if node.HasFlag("master") && !node.HasFlag("fail") && node.SlotCount() > 0 {
+			masters = append(masters, node)
+		}
+
+		if node.MasterID == departing.NodeID && !node.HasFlag("fail") {
+			replicas = append(replicas, node)
+		}
+	}
+
+	if len(replicas) > 0 && len(masters) == 0 {
+		return fmt.Errorf("unable to remove node %s: no masters remain for its %d replicas to follow", a.nodeName, len(replicas))
+	}
+
+	for i, replica := range replicas {
+		master := masters[i%len(masters)]
+		if err := replicateRedisNode(auth, replica.IP, master.ID); err != nil {
+			return err
+		}
+		log.Info("moved replica off unreachable redis node", "replica", replica.ID, "master", master.ID)
+	}
+	if len(replicas) > 0 {
+		return requeueAfter(2*time.Second, "waiting for replicas of node %s to follow another master", a.nodeName)
+	}
+
+	for _, survivor := range healthy {
+		if err := forgetRedisNode(auth, survivor.IP, departing.NodeID); err != nil {
+			return err
+		}
+	}
+	log.Info("forgot unreachable redis node", "nodeID", departing.NodeID)
+
+	return a.deleteRedisNode()
+}
+
+// deleteRedisNode deletes the pod of the departing node, and its PVC when the cluster's
+// retention policy is Delete, as when the cluster itself is deleted
+func (a *RemoveRedisNode) deleteRedisNode() error {
+	log := a.log.WithValues("rediscluster", a.redisCluster.Name, "node", a.nodeName)
+
+	pod := &corev1.Pod{}
+	pod.Name = redisNodePodName(a.redisCluster, a.nodeName)
+	pod.Namespace = a.redisCluster.Namespace
+	if err := a.k8sClient.Delete(context.TODO(), pod); err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	log.Info("deleted redis node pod", "pod", pod.Name)
+
+	if a.redisCluster.Spec.PVCRetentionPolicy == dbv1beta1.PVCRetentionPolicyDelete {
+		pvc := &corev1.PersistentVolumeClaim{}
+		pvc.Name = redisNodePVCName(a.redisCluster, a.nodeName)
+		pvc.Namespace = a.redisCluster.Namespace
+		if err := a.k8sClient.Delete(context.TODO(), pvc); err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+		log.Info("deleted redis node pvc", "pvc", pvc.Name)
+	}
+
+	return requeueAfter(time.Second, "waiting for pod %s to terminate", pod.Name)
+}
+
+type ReplaceRedisNode struct {
+	redisCluster *dbv1beta1.RedisCluster
+	nodeName     string
+	k8sClient    client.Client
+	log          logr.Logger
+}
+
+// Execute replaces a node that the cluster has flagged as failing and whose pod isn't ready,
+// e.g. because the k8s node it ran on was lost along with its volume. Once the pod has been
+// unready for redisNodeReplacementDelay, a replica takes over any slots the node still
+// serves, the survivors forget it and its pod and PVC are deleted, leaving AddRedisNode to
+// provision a replacement that rejoins as a replica of its shard. A master without a
+// replica takes its data with it, so its slots are handed to another master empty.
+func (a *ReplaceRedisNode) Execute() error {
+	log := a.log.WithValues("rediscluster", a.redisCluster.Name, "node", a.nodeName)
+	observed := statusNodesByName(a.redisCluster)
+	failed := observed[a.nodeName]
+
+	auth, err := redisClusterAuth(a.k8sClient, a.redisCluster)
+	if err != nil {
+		return err
+	}
+
+	// the pod is named up front so that it's still deleted by name if it's already gone
+	pod := &corev1.Pod{}
+	pod.Name = redisNodePodName(a.redisCluster, a.nodeName)
+	pod.Namespace = a.redisCluster.Namespace
+	if err := a.k8sClient.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}, pod); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return err
+		}
+	} else if wait := redisNodeReplacementDelay - time.Since(podUnreadySince(pod)); wait > 0 {
+		return requeueAfter(wait, "waiting %s before replacing failed node %s", wait.Round(time.Second), a.nodeName)
+	}
+
+	survivors := []dbv1beta1.RedisNodeStatus{}
+	for name, node := range observed {
+		if name != a.nodeName && node.Ready && !node.Failed {
+			survivors = append(survivors, node)
+		}
+	}
+	if len(survivors) == 0 {
+		return fmt.Errorf("unable to replace node %s: no healthy nodes remain", a.nodeName)
+	}
+
+	if failed.NodeID != "" {
+		_, clusterNodes, err := observeRedisNode(auth, survivors[0].IP)
+		if err != nil {
+			return err
+		}
+
+		var failedNode *redis.ClusterNode
+		for i := range clusterNodes {
+			if clusterNodes[i].ID == failed.NodeID {
+				failedNode = &clusterNodes[i]
+			}
+		}
+
+		if failedNode != nil && failedNode.SlotCount() > 0 {
+			return a.takeOver(auth, *failedNode, clusterNodes, survivors)
+		}
+
+		for _, survivor := range survivors {
+			if err := forgetRedisNode(auth, survivor.IP, failed.NodeID); err != nil {
+				return err
+			}
+		}
+		log.Info("forgot failed redis node", "nodeID", failed.NodeID)
+	}
+
+	if err := a.k8sClient.Delete(context.TODO(), pod, client.GracePeriodSeconds(0)); err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	pvc.Name = redisNodePVCName(a.redisCluster, a.nodeName)
+	pvc.Namespace = a.redisCluster.Namespace
+	if err := a.k8sClient.Delete(context.TODO(), pvc); err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	log.Info("deleted failed redis node", "pod", pod.Name, "pvc", pvc.Name)
+
+	return requeueAfter(time.Second, "waiting for failed node %s to be replaced", a.nodeName)
+}
+
+// takeOver moves the slots of a failed master to one of its replicas with CLUSTER FAILOVER
+// TAKEOVER, or, when it has no replica, to the master serving the fewest slots
+func (a *ReplaceRedisNode) takeOver(auth redisAuth, failedNode redis.ClusterNode, clusterNodes []redis.ClusterNode, survivors []dbv1beta1.RedisNodeStatus) error {
+	log := a.log.WithValues("rediscluster", a.redisCluster.Name, "node", a.nodeName)
+
+	healthy := map[string]bool{}
+	for _, survivor := range survivors {
+		healthy[survivor.NodeID] = true
+	}
+
+	var fallback *redis.ClusterNode
+	for i, node := range clusterNodes {
+		if !healthy[node.ID] {
+			continue
+		}
+
+		if node.MasterID == failedNode.ID {
+			client, err := dialRedisNode(auth, node.IP)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			if err := client.ClusterFailover("TAKEOVER"); err != nil {
+				return err
+			}
+			log.Info("promoted replica of failed redis node", "replica", node.ID)
+
+			return requeueAfter(2*time.Second, "waiting for replica %s to take over from failed node %s", node.ID, a.nodeName)
+		}
+
+		if node.HasFlag("master") && (fallback == nil || node.SlotCount() < fallback.SlotCount()) {
+			fallback = &clusterNodes[i]
+		}
+	}
+
+	if fallback == nil {
+		return fmt.Errorf("unable to replace node %s: no healthy masters remain to take over its %d slots", a.nodeName, failedNode.SlotCount())
+	}
+
+	// the failed node must be forgotten for its slots to become free to claim
+	for _, survivor := range survivors {
+		if err := forgetRedisNode(auth, survivor.IP, failedNode.ID); err != nil {
+			return err
+		}
+	}
+
+	client, err := dialRedisNode(auth, fallback.IP)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.ClusterAddSlots(failedNode.SlotList()...); err != nil {
+		return err
+	}
+	log.Info("reassigned slots of failed redis node without a replica, its data is lost", "slots", failedNode.SlotCount(), "master", fallback.ID)
+
+	return requeueAfter(2*time.Second, "waiting for the slots of failed node %s to be reassigned", a.nodeName)
+}
+
+type UpdateRedisNodeDiskSize struct {
+	redisCluster *dbv1beta1.RedisCluster
+	// nodeIndex is the index of the node within the cluster's desired nodes
+	nodeIndex int
+	k8sClient client.Client
+	log       logr.Logger
+}
+
+// Execute expands the PVC of the node at nodeIndex to the size in its spec and records the
+// new size in the status once the volume and its filesystem have been resized
+func (a *UpdateRedisNodeDiskSize) Execute() error {
+	node := a.redisCluster.Spec.DesiredNodes()[a.nodeIndex]
+	log := a.log.WithValues("rediscluster", a.redisCluster.Name, "node", node.Name)
+	desired := node.DiskSize
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := a.k8sClient.Get(context.TODO(), types.NamespacedName{Name: redisNodePVCName(a.redisCluster, node.Name), Namespace: a.redisCluster.Namespace}, pvc); err != nil {
+		return err
+	}
+
+	requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	switch desired.Cmp(requested) {
+	case -1:
+		return fmt.Errorf("unable to resize pvc %s from %s to %s: volumes cannot be shrunk", pvc.Name, requested.String(), desired.String())
+	case 1:
+		if err := a.checkVolumeExpansion(pvc); err != nil {
+			return err
+		}
+
+		pvc.Spec.Resources.Requests[corev1.ResourceStorage] = desired
+		if err := a.k8sClient.Update(context.TODO(), pvc); err != nil {
+			return err
+		}
+		log.Info("requested redis node volume expansion", "pvc", pvc.Name, "from", requested.String(), "to", desired.String())
+	}
+
+	if isPVCResizing(pvc) {
+		return requeueAfter(10*time.Second, "waiting for pvc %s to be resized to %s", pvc.Name, desired.String())
+	}
+
+	capacity := pvc.Status.Capacity[corev1.ResourceStorage]
+	if capacity.Cmp(desired) < 0 {
+		return requeueAfter(10*time.Second, "waiting for pvc %s capacity %s to reach %s", pvc.Name, capacity.String(), desired.String())
+	}
+
+	return updateStatus(a.k8sClient, a.redisCluster, func(redisCluster *dbv1beta1.RedisCluster) {
+		for i := range redisCluster.Status.Nodes {
+			if statusNodeName(i, redisCluster.Status.Nodes[i]) == node.Name {
+				redisCluster.Status.Nodes[i].DiskSize = node.DiskSize.DeepCopy()
+			}
+		}
+	})
+}
+
+// checkVolumeExpansion returns an error if the storage class of the given PVC does not allow volume expansion
+func (a *UpdateRedisNodeDiskSize) checkVolumeExpansion(pvc *corev1.PersistentVolumeClaim) error {
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return fmt.Errorf("unable to expand pvc %s: it has no storage class", pvc.Name)
+	}
+
+	storageClass := &storagev1.StorageClass{}
+	if err := a.k8sClient.Get(context.TODO(), types.NamespacedName{Name: *pvc.Spec.StorageClassName}, storageClass); err != nil {
+		return err
+	}
+
+	if storageClass.AllowVolumeExpansion == nil || !*storageClass.AllowVolumeExpansion {
+		return fmt.Errorf("unable to expand pvc %s: storage class %s does not allow volume expansion", pvc.Name, storageClass.Name)
+	}
+
+	return nil
+}
+
+type ConfigureRedisNode struct {
+	redisCluster *dbv1beta1.RedisCluster
+	nodeName     string
+	k8sClient    client.Client
+	log          logr.Logger
+}
+
+// Execute brings the configuration of the named node in line with the spec. Settings that
+// redis allows to be changed at runtime are applied with CONFIG SET. Any other change needs
+// the node to be restarted, so its pod is deleted for AddRedisNode to recreate, once a master
+// has handed its slots to a replica.
+func (a *ConfigureRedisNode) Execute() error {
+	log := a.log.WithValues("rediscluster", a.redisCluster.Name, "node", a.nodeName)
+	node := statusNodesByName(a.redisCluster)[a.nodeName]
+
+	// the ConfigMap is updated first so that a restarted node reads the new configuration
+	if err := applyRedisConfigMap(a.k8sClient, a.redisCluster); err != nil {
+		return err
+	}
+
+	auth, err := redisClusterAuth(a.k8sClient, a.redisCluster)
+	if err != nil {
+		return err
+	}
+
+	pod := &corev1.Pod{}
+	if err := a.k8sClient.Get(context.TODO(), types.NamespacedName{Name: redisNodePodName(a.redisCluster, a.nodeName), Namespace: a.redisCluster.Namespace}, pod); err != nil {
+		return err
+	}
+
+	if pod.Annotations[redisStaticConfigHashAnnotation] != redisStaticConfigHash(a.redisCluster) {
+		return restartRedisNode(a.k8sClient, a.redisCluster, auth, a.nodeName, pod, "to apply its configuration", log)
+	}
+
+	client, err := dialRedisNode(auth, node.IP)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	for _, directive := range redisConfigDirectives(a.redisCluster) {
+		if !liveRedisConfig[directive.name] {
+			continue
+		}
+
+		if err := client.ConfigSet(directive.name, directive.value); err != nil {
+			return fmt.Errorf("unable to set %s on node %s: %s", directive.name, a.nodeName, err)
+		}
+	}
+
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[redisConfigHashAnnotation] = redisConfigHash(a.redisCluster)
+	if err := a.k8sClient.Update(context.TODO(), pod); err != nil {
+		return err
+	}
+	log.Info("applied redis node configuration at runtime")
+
+	return nil
+}
+
+// restartRedisNode deletes the pod of a node so that it's recreated with the current
+// configuration and certificate. A master serving slots first fails over to a healthy
+// replica so that its shard stays available, and is restarted once it has become a replica.
+func restartRedisNode(k8sClient client.Client, redisCluster *dbv1beta1.RedisCluster, auth redisAuth, nodeName string, pod *corev1.Pod, reason string, log logr.Logger) error {
+	node := statusNodesByName(redisCluster)[nodeName]
+
+	if node.Role == dbv1beta1.RedisNodeRoleMaster && node.Slots > 0 {
+		for _, replica := range redisCluster.Status.Nodes {
+			if replica.Role != dbv1beta1.RedisNodeRoleReplica || replica.MasterName != nodeName || !replica.Ready || replica.Failed {
+				continue
+			}
+
+			client, err := dialRedisNode(auth, replica.IP)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			if err := client.ClusterFailover(""); err != nil {
+				return err
+			}
+			log.Info("failing over redis node ahead of a restart", "replica", replica.Name)
+
+			return requeueAfter(5*time.Second, "waiting for replica %s to take over from %s before it restarts", replica.Name, nodeName)
+		}
+
+		log.Info("restarting redis master without a replica, its slots are unavailable until it's back")
+	}
+
+	if err := k8sClient.Delete(context.TODO(), pod); err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	log.Info("restarting redis node "+reason, "pod", pod.Name)
+
+	return requeueAfter(time.Second, "waiting for node %s to restart", nodeName)
+}
+
+type RenewRedisNodeCertificate struct {
+	redisCluster *dbv1beta1.RedisCluster
+	nodeName     string
+	k8sClient    client.Client
+	log          logr.Logger
+}
+
+// Execute restarts the named node with a renewed certificate. A certificate issued by the
+// controller is renewed first, whereas a referenced certificate must have been renewed by
+// whatever manages it, such as cert-manager, before the node is restarted.
+func (a *RenewRedisNodeCertificate) Execute() error {
+	log := a.log.WithValues("rediscluster", a.redisCluster.Name, "node", a.nodeName)
+
+	if a.redisCluster.Spec.TLS.CertificateSecret == nil {
+		if err := applyRedisNodeCertificate(a.k8sClient, a.redisCluster, a.nodeName); err != nil {
+			return err
+		}
+	} else {
+		secret := &corev1.Secret{}
+		name := a.redisCluster.Spec.TLS.CertificateSecret.Name
+		if err := a.k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: a.redisCluster.Namespace}, secret); err != nil {
+			return err
+		}
+
+		notAfter, err := certificateNotAfter(secret.Data[corev1.TLSCertKey])
+		if err != nil {
+			return fmt.Errorf("invalid certificate in secret %s: %s", name, err)
+		}
+		if isCertificateExpiring(a.redisCluster, notAfter) {
+			return fmt.Errorf("the certificate in secret %s expires at %s and must be renewed before nodes can be restarted with it", name, notAfter.Format(time.RFC3339))
+		}
+	}
+
+	auth, err := redisClusterAuth(a.k8sClient, a.redisCluster)
+	if err != nil {
+		return err
+	}
+
+	pod := &corev1.Pod{}
+	if err := a.k8sClient.Get(context.TODO(), types.NamespacedName{Name: redisNodePodName(a.redisCluster, a.nodeName), Namespace: a.redisCluster.Namespace}, pod); err != nil {
+		return err
+	}
+
+	return restartRedisNode(a.k8sClient, a.redisCluster, auth, a.nodeName, pod, "to serve its renewed certificate", log)
+}
+
+type RotateRedisPassword struct {
+	redisCluster *dbv1beta1.RedisCluster
+	k8sClient    client.Client
+	log          logr.Logger
+}
+
+// Execute rolls out a new password without refusing clients that still use the old one. The
+// new password is first added alongside the old one on every node, then the old one is
+// removed once clients have had the grace period to switch over. Nodes that didn't require
+// a password accept any password, so clients can switch before the old one is removed.
+func (a *RotateRedisPassword) Execute() error {
+	log := a.log.WithValues("rediscluster", a.redisCluster.Name)
+	authHash := a.redisCluster.Status.AuthHash
+
+	password, err := desiredRedisPassword(a.k8sClient, a.redisCluster)
+	if err != nil {
+		return err
+	}
+
+	if rotation := a.redisCluster.Status.PasswordRotation; rotation == nil || rotation.AuthHash != authHash {
+		secret, err := getRedisAuthSecret(a.k8sClient, a.redisCluster)
+		if err != nil {
+			return err
+		}
+
+		// a rotation that is superseded keeps the password it was rotating from, as nodes the
+		// superseded password wasn't added to only accept that one
+		previous := ""
+		if secret != nil && rotation == nil {
+			previous = string(secret.Data[redisPasswordKey])
+		} else if secret != nil {
+			previous = string(secret.Data[redisPreviousPasswordKey])
+		}
+
+		if err := applyRedisAuthSecret(a.k8sClient, a.redisCluster, password, previous); err != nil {
+			return err
+		}
+
+		err = updateStatus(a.k8sClient, a.redisCluster, func(redisCluster *dbv1beta1.RedisCluster) {
+			redisCluster.Status.PasswordRotation = &dbv1beta1.RedisPasswordRotation{
+				AuthHash: authHash,
+				Phase:    dbv1beta1.RedisPasswordRotationAdding,
+			}
+		})
+		if err != nil {
+			return err
+		}
+		log.Info("rotating redis password")
+	}
+
+	auth, err := redisClusterAuth(a.k8sClient, a.redisCluster)
+	if err != nil {
+		return err
+	}
+
+	if a.redisCluster.Status.PasswordRotation.Phase == dbv1beta1.RedisPasswordRotationAdding {
+		return a.addPassword(auth, password)
+	}
+
+	return a.removePreviousPassword(auth, password)
+}
+
+// addPassword makes every node accept the new password as well as the old one, and has
+// replicas authenticate to their master with the new one
+func (a *RotateRedisPassword) addPassword(auth redisAuth, password string) error {
+	rule := ">" + password
+	if password == "" {
+		rule = "nopass"
+	}
+
+	for _, node := range a.redisCluster.Status.Nodes {
+		if node.AuthHash == "" && password != "" {
+			continue // the node already accepts any password
+		}
+
+		if err := setRedisUser(auth, node.IP, redisDefaultUser, []string{rule}); err != nil {
+			return fmt.Errorf("unable to add the new password to node %s: %s", node.Name, err)
+		}
+	}
+
+	// replicas only authenticate with the new password once every master accepts it
+	for _, node := range a.redisCluster.Status.Nodes {
+		if err := setRedisConfig(auth, node.IP, "masterauth", password); err != nil {
+			return fmt.Errorf("unable to set masterauth on node %s: %s", node.Name, err)
+		}
+	}
+
+	now := metav1.Now()
+	err := updateStatus(a.k8sClient, a.redisCluster, func(redisCluster *dbv1beta1.RedisCluster) {
+		if rotation := redisCluster.Status.PasswordRotation; rotation != nil {
+			rotation.Phase = dbv1beta1.RedisPasswordRotationGracePeriod
+			rotation.GracePeriodStartTime = &now
+		}
+	})
+	if err != nil {
+		return err
+	}
+	a.log.Info("added the new redis password to every node", "rediscluster", a.redisCluster.Name)
+
+	if gracePeriod := rotationGracePeriod(a.redisCluster); gracePeriod > 0 {
+		return requeueAfter(gracePeriod, "waiting for clients to switch to the new password")
+	}
+
+	return a.removePreviousPassword(auth, password)
+}
+
+// removePreviousPassword removes the old password from every node once the grace period has
+// passed, completing the rotation
+func (a *RotateRedisPassword) removePreviousPassword(auth redisAuth, password string) error {
+	rotation := a.redisCluster.Status.PasswordRotation
+	if rotation.GracePeriodStartTime != nil {
+		if remaining := rotationGracePeriod(a.redisCluster) - time.Since(rotation.GracePeriodStartTime.Time); remaining > 0 {
+			return requeueAfter(remaining, "waiting for clients to switch to the new password")
+		}
+	}
+
+	rules := []string{"resetpass", ">" + password}
+	if password == "" {
+		rules = []string{"nopass"}
+	}
+
+	for _, node := range a.redisCluster.Status.Nodes {
+		if err := setRedisUser(auth, node.IP, redisDefaultUser, rules); err != nil {
+			return fmt.Errorf("unable to remove the old password from node %s: %s", node.Name, err)
+		}
+
+		pod := &corev1.Pod{}
+		if err := a.k8sClient.Get(context.TODO(), types.NamespacedName{Name: redisNodePodName(a.redisCluster, node.Name), Namespace: a.redisCluster.Namespace}, pod); err != nil {
+			return err
+		}
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[redisAuthHashAnnotation] = rotation.AuthHash
+		if err := a.k8sClient.Update(context.TODO(), pod); err != nil {
+			return err
+		}
+	}
+
+	if err := applyRedisAuthSecret(a.k8sClient, a.redisCluster, password, ""); err != nil {
+		return err
+	}
+
+	err := updateStatus(a.k8sClient, a.redisCluster, func(redisCluster *dbv1beta1.RedisCluster) {
+		redisCluster.Status.PasswordRotation = nil
+	})
+	if err != nil {
+		return err
+	}
+	a.log.Info("rotated redis password", "rediscluster", a.redisCluster.Name)
+
+	return nil
+}
+
+type AwaitRedisNodes struct {
+	redisCluster *dbv1beta1.RedisCluster
+}
+
+// Execute requeues the cluster until every node's pod is ready
+func (a *AwaitRedisNodes) Execute() error {
+	notReady := 0
+	for _, node := range a.redisCluster.Status.Nodes {
+		if !node.Ready {
+			notReady++
+		}
+	}
+
+	return requeueAfter(5*time.Second, "waiting for %d redis nodes to become ready", notReady)
+}
+
+type BootstrapRedisCluster struct {
+	redisCluster *dbv1beta1.RedisCluster
+	k8sClient    client.Client
+	log          logr.Logger
+}
+
+// Execute introduces every node that has not yet joined to the cluster with CLUSTER MEET.
+// When none of the nodes have joined, i.e. the cluster is being created, the hash slots are
+// also spread evenly across the first node of each shard. The nodes are marked as joined
+// once the cluster reports that it is healthy.
+func (a *BootstrapRedisCluster) Execute() error {
+	log := a.log.WithValues("rediscluster", a.redisCluster.Name)
+	nodes := a.redisCluster.Status.Nodes
+
+	auth, err := redisClusterAuth(a.k8sClient, a.redisCluster)
+	if err != nil {
+		return err
+	}
+
+	seed := 0
+	creating := true
+	for i, node := range nodes {
+		if node.Joined {
+			seed = i
+			creating = false
+			break
+		}
+	}
+
+	seedClient, err := dialRedisNode(auth, nodes[seed].IP)
+	if err != nil {
+		return err
+	}
+	defer seedClient.Close()
+
+	for i, node := range nodes {
+		if i == seed || node.Joined {
+			continue
+		}
+
+		if err := seedClient.ClusterMeet(node.IP, redisPort); err != nil {
+			return err
+		}
+	}
+
+	clusterNodes, err := seedClient.ClusterNodes()
+	if err != nil {
+		return err
+	}
+
+	// a node recreated without its data, e.g. when it doesn't persist it, rejoins with a new
+	// id, leaving its old one failed in every node table until it's forgotten
+	stale := staleClusterNodes(clusterNodes, nodes)
+	for _, id := range stale {
+		for _, node := range nodes {
+			if err := forgetRedisNode(auth, node.IP, id); err != nil {
+				return err
+			}
+		}
+		log.Info("forgot stale redis node", "nodeID", id)
+	}
+	if len(stale) > 0 {
+		return requeueAfter(time.Second, "waiting for %d stale nodes to be forgotten", len(stale))
+	}
+
+	known := 0
+	for _, node := range clusterNodes {
+		if !node.HasFlag("handshake") && !node.HasFlag("noaddr") {
+			known++
+		}
+	}
+
+	if known < len(nodes) {
+		return requeueAfter(2*time.Second, "waiting for %d nodes to complete the cluster handshake", len(nodes)-known)
+	}
+
+	if creating {
+		observed := statusNodesByName(a.redisCluster)
+		masters := []dbv1beta1.RedisNodeStatus{}
+		for _, shard := range a.redisCluster.Spec.Shards() {
+			if node, ok := observed[shard[0].Name]; ok {
+				masters = append(masters, node)
+			}
+		}
+
+		if err := assignSlots(auth, masters, clusterNodes); err != nil {
+			return err
+		}
+	}
+
+	for _, node := range nodes {
+		ok, err := isClusterStateOK(auth, node.IP, len(nodes))
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return requeueAfter(2*time.Second, "waiting for node %s to report cluster_state:ok", node.IP)
+		}
+	}
+	log.Info("redis cluster nodes joined", "nodes", len(nodes))
+
+	return updateStatus(a.k8sClient, a.redisCluster, func(redisCluster *dbv1beta1.RedisCluster) {
+		for i := range redisCluster.Status.Nodes {
+			redisCluster.Status.Nodes[i].Joined = true
+		}
+	})
+}
+
+type ReplicateRedisNode struct {
+	redisCluster *dbv1beta1.RedisCluster
+	nodeName     string
+	masterName   string
+	k8sClient    client.Client
+	log          logr.Logger
+}
+
+// Execute makes the named node a replica of the master of its shard with CLUSTER REPLICATE.
+// A node serving slots, e.g. the master of a shard that has been merged into another by an
+// increase in ReplicasPerMaster, first migrates its slots to the shard's master.
+func (a *ReplicateRedisNode) Execute() error {
+	log := a.log.WithValues("rediscluster", a.redisCluster.Name, "node", a.nodeName, "master", a.masterName)
+	observed := statusNodesByName(a.redisCluster)
+	node, master := observed[a.nodeName], observed[a.masterName]
+
+	auth, err := redisClusterAuth(a.k8sClient, a.redisCluster)
+	if err != nil {
+		return err
+	}
+
+	if master.NodeID == "" {
+		return requeueAfter(2*time.Second, "waiting for the id of master %s to be observed", a.masterName)
+	}
+
+	source, err := dialRedisNode(auth, node.IP)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	if node.Slots > 0 {
+		clusterNode, _, err := observeRedisNode(auth, node.IP)
+		if err != nil {
+			return err
+		}
+
+		destination, err := dialRedisNode(auth, master.IP)
+		if err != nil {
+			return err
+		}
+		defer destination.Close()
+
+		for _, slot := range clusterNode.SlotList() {
+			if err := migrateSlot(source, destination, clusterNode.ID, master.NodeID, master.IP, slot); err != nil {
+				return fmt.Errorf("unable to migrate slot %d to %s: %s", slot, a.masterName, err)
+			}
+		}
+		log.Info("migrated slots to shard master", "slots", clusterNode.SlotCount())
 	}
 
-	if action != nil {
-		return ctrl.Result{}, action.Execute()
+	if err := source.ClusterReplicate(master.NodeID); err != nil {
+		return err
 	}
+	log.Info("redis node replicating master")
 
-	return ctrl.Result{}, nil // no action to take
+	return requeueAfter(2*time.Second, "waiting for node %s to replicate %s", a.nodeName, a.masterName)
 }
 
-func (r *RedisClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&dbv1beta1.RedisCluster{}).
-		Complete(r)
+type DetachRedisNode struct {
+	redisCluster *dbv1beta1.RedisCluster
+	nodeName     string
+	k8sClient    client.Client
+	log          logr.Logger
 }
 
-type AddRedisNode struct {
+// Execute turns a replica into an empty master by resetting its cluster state, for shards
+// without a master, e.g. after a decrease in ReplicasPerMaster. The node is then reintroduced
+// to the cluster by BootstrapRedisCluster.
+func (a *DetachRedisNode) Execute() error {
+	node := statusNodesByName(a.redisCluster)[a.nodeName]
+
+	auth, err := redisClusterAuth(a.k8sClient, a.redisCluster)
+	if err != nil {
+		return err
+	}
+
+	client, err := dialRedisNode(auth, node.IP)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.ClusterReset(false); err != nil {
+		return err
+	}
+	a.log.Info("detached redis node from its master", "rediscluster", a.redisCluster.Name, "node", a.nodeName)
+
+	return updateStatus(a.k8sClient, a.redisCluster, func(redisCluster *dbv1beta1.RedisCluster) {
+		for i := range redisCluster.Status.Nodes {
+			if statusNodeName(i, redisCluster.Status.Nodes[i]) == a.nodeName {
+				redisCluster.Status.Nodes[i].Joined = false
+			}
+		}
+	})
+}
+
+type RebalanceRedisCluster struct {
 	redisCluster *dbv1beta1.RedisCluster
 	k8sClient    client.Client
 	log          logr.Logger
 }
 
-func (a *AddRedisNode) Execute() error {
-	return nil
+// Execute migrates a batch of slots between the masters of the cluster's shards towards an
+// even distribution, e.g. after shards have been added, pausing between batches so that the
+// migration doesn't starve clients. Progress is recorded in the status, which is cleared once
+// the slots are evenly distributed.
+func (a *RebalanceRedisCluster) Execute() error {
+	log := a.log.WithValues("rediscluster", a.redisCluster.Name)
+
+	masters, _ := shardMasters(a.redisCluster.Spec.Shards(), statusNodesByName(a.redisCluster))
+	if len(masters) == 0 {
+		return nil
+	}
+
+	auth, err := redisClusterAuth(a.k8sClient, a.redisCluster)
+	if err != nil {
+		return err
+	}
+
+	_, clusterNodes, err := observeRedisNode(auth, masters[0].IP)
+	if err != nil {
+		return err
+	}
+
+	isShardMaster := map[string]bool{}
+	for _, master := range masters {
+		isShardMaster[master.NodeID] = true
+	}
+
+	clusterMasters := []redis.ClusterNode{}
+	for _, node := range clusterNodes {
+		if isShardMaster[node.ID] {
+			clusterMasters = append(clusterMasters, node)
+		}
+	}
+
+	moves, remaining := planRebalance(clusterMasters, rebalanceBatchSlots)
+	for _, move := range moves {
+		if err := a.migrate(auth, move); err != nil {
+			return fmt.Errorf("unable to migrate slot %d to %s: %s", move.slot, move.destination.ID, err)
+		}
+	}
+	if len(moves) > 0 {
+		log.Info("migrated slots to rebalance the cluster", "slots", len(moves), "remaining", remaining)
+	}
+
+	err = updateStatus(a.k8sClient, a.redisCluster, func(redisCluster *dbv1beta1.RedisCluster) {
+		if remaining == 0 {
+			redisCluster.Status.Rebalance = nil
+			return
+		}
+
+		if redisCluster.Status.Rebalance == nil {
+			redisCluster.Status.Rebalance = &dbv1beta1.RedisRebalanceStatus{StartTime: metav1.Now()}
+		}
+		redisCluster.Status.Rebalance.SlotsMoved += int32(len(moves))
+		redisCluster.Status.Rebalance.SlotsRemaining = int32(remaining)
+	})
+	if err != nil {
+		return err
+	}
+
+	if remaining == 0 {
+		log.Info("redis cluster slots rebalanced")
+		return nil
+	}
+
+	return requeueAfter(rebalanceBatchInterval, "rebalancing slots, %d remaining", remaining)
 }
 
-type RemoveRedisNode struct {
+func (a *RebalanceRedisCluster) migrate(auth redisAuth, move slotMove) error {
+	source, err := dialRedisNode(auth, move.source.IP)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := dialRedisNode(auth, move.destination.IP)
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+
+	return migrateSlot(source, destination, move.source.ID, move.destination.ID, move.destination.IP, move.slot)
+}
+
+type FixRedisCluster struct {
 	redisCluster *dbv1beta1.RedisCluster
 	k8sClient    client.Client
 	log          logr.Logger
 }
 
-func (a *RemoveRedisNode) Execute() error {
+// Execute brings the cluster back to a consistent state after an operation was interrupted,
+// in the manner of redis-cli --cluster fix. Slots that no node serves are assigned first,
+// to the master holding the most of their keys, after which slots left migrating or
+// importing are closed: a migration to a reachable node is completed, otherwise any keys
+// that moved are returned to the slot's owner.
+func (a *FixRedisCluster) Execute() error {
+	log := a.log.WithValues("rediscluster", a.redisCluster.Name)
+
+	auth, err := redisClusterAuth(a.k8sClient, a.redisCluster)
+	if err != nil {
+		return err
+	}
+
+	nodes := []redis.ClusterNode{}
+	views := [][]redis.ClusterNode{}
+	for _, status := range a.redisCluster.Status.Nodes {
+		if !status.Ready {
+			continue
+		}
+
+		node, view, err := observeRedisNode(auth, status.IP)
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, node)
+		views = append(views, view)
+	}
+
+	if slots := uncoveredSlots(views); len(slots) > 0 {
+		if err := a.coverSlots(auth, slots, nodes); err != nil {
+			return err
+		}
+		log.Info("assigned uncovered slots", "slots", len(slots))
+
+		return requeueAfter(2*time.Second, "waiting for %d assigned slots to be observed", len(slots))
+	}
+
+	slots := openSlots(nodes)
+	for _, slot := range slots {
+		if err := a.closeSlot(auth, slot, nodes); err != nil {
+			return fmt.Errorf("unable to close slot %d: %s", slot, err)
+		}
+	}
+	log.Info("closed open slots", "slots", len(slots))
+
+	return requeueAfter(2*time.Second, "waiting for %d closed slots to be observed", len(slots))
+}
+
+// coverSlots assigns each of the given slots to the master holding the most of its keys, or
+// to the master serving the fewest slots when none hold any. Keys held by other masters are
+// moved to the new owner.
+func (a *FixRedisCluster) coverSlots(auth redisAuth, slots []int, nodes []redis.ClusterNode) error {
+	// slots go to masters that already serve slots rather than to nodes due to become replicas
+	masters := []redis.ClusterNode{}
+	for _, node := range nodes {
+		if node.HasFlag("master") && node.SlotCount() > 0 {
+			masters = append(masters, node)
+		}
+	}
+	if len(masters) == 0 {
+		for _, node := range nodes {
+			if node.HasFlag("master") {
+				masters = append(masters, node)
+			}
+		}
+	}
+	if len(masters) == 0 {
+		return fmt.Errorf("unable to assign %d uncovered slots: no masters can be reached", len(slots))
+	}
+
+	clients := make([]*redis.Client, len(masters))
+	assigned := make([]int, len(masters))
+	for i, master := range masters {
+		var err error
+		if clients[i], err = dialRedisNode(auth, master.IP); err != nil {
+			return err
+		}
+		defer clients[i].Close()
+		assigned[i] = master.SlotCount()
+	}
+
+	for _, slot := range slots {
+		keys := make([]int, len(masters))
+		owner := 0
+		for i := range masters {
+			var err error
+			if keys[i], err = clients[i].ClusterCountKeysInSlot(slot); err != nil {
+				return err
+			}
+
+			if keys[i] > keys[owner] || (keys[owner] == 0 && assigned[i] < assigned[owner]) {
+				owner = i
+			}
+		}
+
+		if err := clients[owner].ClusterAddSlots(slot); err != nil {
+			return err
+		}
+		assigned[owner]++
+
+		for i := range masters {
+			if i == owner || keys[i] == 0 {
+				continue
+			}
+
+			// the slot must be open on a node for it to migrate keys in a slot it doesn't serve
+			if err := clients[i].ClusterSetSlotImporting(slot, masters[owner].ID); err != nil {
+				return err
+			}
+			if err := migrateKeys(clients[i], masters[owner].IP, clients[owner].Password(), slot); err != nil {
+				return err
+			}
+			if err := clients[i].ClusterSetSlotStable(slot); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
-type UpdateRedisNodeDiskSize struct {
+// closeSlot completes or reverts the migration of a slot left open on any of the given nodes
+func (a *FixRedisCluster) closeSlot(auth redisAuth, slot int, nodes []redis.ClusterNode) error {
+	byID := map[string]redis.ClusterNode{}
+	var owner *redis.ClusterNode
+	for i, node := range nodes {
+		byID[node.ID] = node
+		for _, r := range node.Slots {
+			if slot >= r.Start && slot <= r.End {
+				owner = &nodes[i]
+			}
+		}
+	}
+
+	if owner == nil {
+		// the owner is unreachable, so any keys that moved can't be returned to it
+		a.log.Info("closing open slot whose owner can't be reached", "rediscluster", a.redisCluster.Name, "slot", slot)
+		for _, node := range nodes {
+			if err := setSlotStable(auth, node, slot); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	target := *owner
+	ownerClient, err := dialRedisNode(auth, owner.IP)
+	if err != nil {
+		return err
+	}
+	defer ownerClient.Close()
+	targetClient := ownerClient
+
+	if destination, ok := byID[owner.Migrating[slot]]; ok {
+		destinationClient, err := dialRedisNode(auth, destination.IP)
+		if err != nil {
+			return err
+		}
+		defer destinationClient.Close()
+
+		if err := migrateSlot(ownerClient, destinationClient, owner.ID, destination.ID, destination.IP, slot); err != nil {
+			return err
+		}
+		target, targetClient = destination, destinationClient
+	} else if err := ownerClient.ClusterSetSlotStable(slot); err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		if node.ID == owner.ID || node.ID == target.ID || !isSlotOpen(node, slot) {
+			continue
+		}
+
+		client, err := dialRedisNode(auth, node.IP)
+		if err != nil {
+			return err
+		}
+
+		err = migrateKeys(client, target.IP, targetClient.Password(), slot)
+		if err == nil {
+			err = client.ClusterSetSlotStable(slot)
+		}
+		client.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type TeardownRedisCluster struct {
 	redisCluster *dbv1beta1.RedisCluster
-	nodeIndex    int
 	k8sClient    client.Client
 	log          logr.Logger
 }
 
-func (a *UpdateRedisNodeDiskSize) Execute() error {
+// Execute deletes the pods of a RedisCluster that is being deleted, and its PVCs unless the
+// retention policy is Retain, before removing the finalizer so that the deletion can complete.
+// Retained PVCs are orphaned so that they aren't garbage collected along with the cluster.
+func (a *TeardownRedisCluster) Execute() error {
+	log := a.log.WithValues("rediscluster", a.redisCluster.Name)
+
+	pods := &corev1.PodList{}
+	if err := a.k8sClient.List(context.TODO(), pods, client.InNamespace(a.redisCluster.Namespace), client.MatchingLabels(redisClusterLabels(a.redisCluster))); err != nil {
+		return err
+	}
+
+	for i := range pods.Items {
+		if err := a.k8sClient.Delete(context.TODO(), &pods.Items[i]); err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+	log.Info("deleted redis node pods", "pods", len(pods.Items))
+
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	if err := a.k8sClient.List(context.TODO(), pvcs, client.InNamespace(a.redisCluster.Namespace), client.MatchingLabels(redisClusterLabels(a.redisCluster))); err != nil {
+		return err
+	}
+
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if a.redisCluster.Spec.PVCRetentionPolicy == dbv1beta1.PVCRetentionPolicyDelete {
+			if err := a.k8sClient.Delete(context.TODO(), pvc); err != nil && !k8serrors.IsNotFound(err) {
+				return err
+			}
+			continue
+		}
+
+		ownerReferences := []metav1.OwnerReference{}
+		for _, ownerReference := range pvc.OwnerReferences {
+			if ownerReference.UID != a.redisCluster.UID {
+				ownerReferences = append(ownerReferences, ownerReference)
+			}
+		}
+
+		if len(ownerReferences) == len(pvc.OwnerReferences) {
+			continue
+		}
+
+		pvc.OwnerReferences = ownerReferences
+		if err := a.k8sClient.Update(context.TODO(), pvc); err != nil {
+			return err
+		}
+	}
+	log.Info("cleaned up redis node pvcs", "pvcs", len(pvcs.Items), "policy", a.redisCluster.Spec.PVCRetentionPolicy)
+
+	a.redisCluster.Finalizers = removeString(a.redisCluster.Finalizers, redisClusterFinalizer)
+
+	return a.k8sClient.Update(context.TODO(), a.redisCluster)
+}
+
+// validateRedisClusterSize returns an error if the desired nodes can't be grouped into at
+// least MinMasters complete shards
+func validateRedisClusterSize(spec *dbv1beta1.RedisClusterSpec) error {
+	nodes := len(spec.DesiredNodes())
+	shardSize := int(spec.ReplicasPerMaster) + 1
+	switch {
+	case shardSize < 1:
+		return fmt.Errorf("unable to scale to %d nodes: replicasPerMaster must be greater than or equal to 0", nodes)
+	case nodes%shardSize != 0:
+		return fmt.Errorf("unable to scale to %d nodes: the number of nodes must be a multiple of replicasPerMaster+1", nodes)
+	case nodes/shardSize < dbv1beta1.MinMasters:
+		return fmt.Errorf("unable to scale to %d nodes: a redis cluster requires at least %d masters", nodes, dbv1beta1.MinMasters)
+	}
+
 	return nil
 }
 
@@ -119,34 +1658,212 @@ func NewRedisClusterActionIdentifier(k8sClient client.Client, log logr.Logger) A
 	}
 }
 
-//  IdentifyAction inspects a RedisCluster resource to determine the delta of highest priority and returns an identifier for an appropriate action
+// IdentifyAction inspects a RedisCluster resource to determine the delta of highest priority and returns an identifier for an appropriate action
+// A cluster being deleted is torn down first. Otherwise, repairing open or uncovered slots takes priority over every other action.
 func (c *RedisClusterActionIdentifier) IdentifyAction(obj runtime.Object) (Action, error) {
 	redisCluster, ok := obj.(*dbv1beta1.RedisCluster)
 	if !ok {
 		return nil, fmt.Errorf("unexpected runtime object: %#v", obj)
 	}
 
-	if len(redisCluster.Spec.Nodes) > len(redisCluster.Status.Nodes) {
-		return &AddRedisNode{
+	if redisCluster.DeletionTimestamp != nil {
+		if !containsString(redisCluster.Finalizers, redisClusterFinalizer) {
+			return nil, nil // teardown complete
+		}
+
+		return &TeardownRedisCluster{
+			redisCluster: redisCluster,
+			k8sClient:    c.k8sClient,
+			log:          c.log,
+		}, nil
+	}
+
+	// slots left open or unserved, e.g. by an interrupted migration, leave the cluster
+	// inconsistent for every other action, so they're repaired before anything but teardown,
+	// which deletes every node regardless and mustn't be held up by unreachable ones
+	if redisCluster.Status.OpenSlots > 0 || redisCluster.Status.UncoveredSlots > 0 {
+		return &FixRedisCluster{
+			redisCluster: redisCluster,
+			k8sClient:    c.k8sClient,
+			log:          c.log,
+		}, nil
+	}
+
+	if auth := redisCluster.Spec.Auth; auth != nil && auth.PasswordSecret == nil && redisCluster.Status.AuthHash == "" {
+		return &GenerateRedisPassword{
 			redisCluster: redisCluster,
 			k8sClient:    c.k8sClient,
 			log:          c.log,
 		}, nil
 	}
 
-	if len(redisCluster.Spec.Nodes) < len(redisCluster.Status.Nodes) {
-		return &RemoveRedisNode{
+	// replicas is written through the scale subresource, which the validating webhook doesn't
+	// see, so a node count that can't form a cluster is refused here rather than acted on
+	if redisCluster.Spec.Replicas != nil {
+		if err := validateRedisClusterSize(&redisCluster.Spec); err != nil {
+			return nil, err
+		}
+	}
+
+	desiredNodes := redisCluster.Spec.DesiredNodes()
+
+	desired := map[string]bool{}
+	for _, node := range desiredNodes {
+		desired[node.Name] = true
+	}
+
+	observed := statusNodesByName(redisCluster)
+
+	for _, node := range desiredNodes {
+		if _, ok := observed[node.Name]; !ok {
+			return &AddRedisNode{
+				redisCluster: redisCluster,
+				k8sClient:    c.k8sClient,
+				log:          c.log,
+			}, nil
+		}
+	}
+
+	for i, node := range redisCluster.Status.Nodes {
+		if name := statusNodeName(i, node); !desired[name] {
+			return &RemoveRedisNode{
+				redisCluster: redisCluster,
+				nodeName:     name,
+				k8sClient:    c.k8sClient,
+				log:          c.log,
+			}, nil
+		}
+	}
+
+	for i, node := range redisCluster.Status.Nodes {
+		if node.Failed && !node.Ready {
+			return &ReplaceRedisNode{
+				redisCluster: redisCluster,
+				nodeName:     statusNodeName(i, node),
+				k8sClient:    c.k8sClient,
+				log:          c.log,
+			}, nil
+		}
+	}
+
+	for _, node := range redisCluster.Status.Nodes {
+		if !node.Ready {
+			return &AwaitRedisNodes{
+				redisCluster: redisCluster,
+			}, nil
+		}
+	}
+
+	for _, node := range redisCluster.Status.Nodes {
+		if !node.Joined {
+			return &BootstrapRedisCluster{
+				redisCluster: redisCluster,
+				k8sClient:    c.k8sClient,
+				log:          c.log,
+			}, nil
+		}
+	}
+
+	for _, shard := range redisCluster.Spec.Shards() {
+		if !rolesObserved(shard, observed) {
+			continue
+		}
+
+		master := shardMaster(shard, observed)
+		if master == "" {
+			return &DetachRedisNode{
+				redisCluster: redisCluster,
+				nodeName:     shard[0].Name,
+				k8sClient:    c.k8sClient,
+				log:          c.log,
+			}, nil
+		}
+
+		for _, node := range shard {
+			if node.Name == master {
+				continue
+			}
+
+			// the shard is missing a replica when the node isn't replicating anything, or
+			// the replica is following the wrong master when it replicates another shard
+			if status := observed[node.Name]; status.Role != dbv1beta1.RedisNodeRoleReplica || status.MasterName != master {
+				return &ReplicateRedisNode{
+					redisCluster: redisCluster,
+					nodeName:     node.Name,
+					masterName:   master,
+					k8sClient:    c.k8sClient,
+					log:          c.log,
+				}, nil
+			}
+		}
+	}
+
+	// a rotation that has started runs to completion, even if the password is changed back,
+	// so that no node is left accepting a password that is no longer current
+	if redisCluster.Status.PasswordRotation != nil || !authHashesCurrent(redisCluster) {
+		return &RotateRedisPassword{
 			redisCluster: redisCluster,
 			k8sClient:    c.k8sClient,
 			log:          c.log,
 		}, nil
 	}
 
-	for i := 0; i < len(redisCluster.Spec.Nodes); i++ {
-		if redisCluster.Spec.Nodes[i].DiskSize != redisCluster.Status.Nodes[i].DiskSize {
-			return &UpdateRedisNodeDiskSize{
+	// disk sizes don't apply to nodes without a persistent volume
+	if redisCluster.Spec.Persistence.Mode != dbv1beta1.PersistenceModeNone {
+		for i, node := range desiredNodes {
+			if diskSize := observed[node.Name].DiskSize; node.DiskSize.Cmp(diskSize) != 0 {
+				return &UpdateRedisNodeDiskSize{
+					redisCluster: redisCluster,
+					nodeIndex:    i,
+					k8sClient:    c.k8sClient,
+					log:          c.log,
+				}, nil
+			}
+		}
+	}
+
+	// replicas are configured before masters so that a master being restarted can hand its
+	// slots to a replica that is already running the new configuration
+	desiredHash := redisConfigHash(redisCluster)
+	for _, role := range []dbv1beta1.RedisNodeRole{dbv1beta1.RedisNodeRoleReplica, dbv1beta1.RedisNodeRoleMaster} {
+		for i, node := range redisCluster.Status.Nodes {
+			if node.Role == role && node.ConfigHash != desiredHash {
+				return &ConfigureRedisNode{
+					redisCluster: redisCluster,
+					nodeName:     statusNodeName(i, node),
+					k8sClient:    c.k8sClient,
+					log:          c.log,
+				}, nil
+			}
+		}
+	}
+
+	// certificates are renewed well ahead of expiry, replicas first for the same reason
+	for _, role := range []dbv1beta1.RedisNodeRole{dbv1beta1.RedisNodeRoleReplica, dbv1beta1.RedisNodeRoleMaster} {
+		for i, node := range redisCluster.Status.Nodes {
+			if node.Role == role && node.CertificateNotAfter != nil && isCertificateExpiring(redisCluster, node.CertificateNotAfter.Time) {
+				return &RenewRedisNodeCertificate{
+					redisCluster: redisCluster,
+					nodeName:     statusNodeName(i, node),
+					k8sClient:    c.k8sClient,
+					log:          c.log,
+				}, nil
+			}
+		}
+	}
+
+	// rebalancing comes last as it can take a while and any other change should preempt it. A
+	// rebalance that has started runs until the slots are evenly distributed, so that the
+	// cluster doesn't settle just within the threshold.
+	if masters, ok := shardMasters(redisCluster.Spec.Shards(), observed); ok {
+		slots := make([]int, len(masters))
+		for i, master := range masters {
+			slots[i] = int(master.Slots)
+		}
+
+		if redisCluster.Status.Rebalance != nil || !isSlotDistributionBalanced(slots, rebalanceThreshold(redisCluster)) {
+			return &RebalanceRedisCluster{
 				redisCluster: redisCluster,
-				nodeIndex:    i,
 				k8sClient:    c.k8sClient,
 				log:          c.log,
 			}, nil
@@ -155,3 +1872,58 @@ func (c *RedisClusterActionIdentifier) IdentifyAction(obj runtime.Object) (Actio
 
 	return nil, nil
 }
+
+// authHashesCurrent reports whether every node requires the password in the spec
+func authHashesCurrent(redisCluster *dbv1beta1.RedisCluster) bool {
+	for _, node := range redisCluster.Status.Nodes {
+		if node.AuthHash != redisCluster.Status.AuthHash {
+			return false
+		}
+	}
+
+	return true
+}
+
+// shardMasters returns the status of the master of every shard, or false if the roles of a
+// shard's nodes haven't all been observed or a shard has no master
+func shardMasters(shards [][]dbv1beta1.RedisNodeSpec, observed map[string]dbv1beta1.RedisNodeStatus) ([]dbv1beta1.RedisNodeStatus, bool) {
+	masters := []dbv1beta1.RedisNodeStatus{}
+	for _, shard := range shards {
+		if !rolesObserved(shard, observed) {
+			return nil, false
+		}
+
+		master := shardMaster(shard, observed)
+		if master == "" {
+			return nil, false
+		}
+		masters = append(masters, observed[master])
+	}
+
+	return masters, true
+}
+
+// rolesObserved reports whether the role of every node in a shard has been observed
+func rolesObserved(shard []dbv1beta1.RedisNodeSpec, observed map[string]dbv1beta1.RedisNodeStatus) bool {
+	for _, node := range shard {
+		if observed[node.Name].Role == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// shardMaster returns the name of the master of a shard, which is the master in the shard
+// serving the most slots, or "" if none of the shard's nodes are masters
+func shardMaster(shard []dbv1beta1.RedisNodeSpec, observed map[string]dbv1beta1.RedisNodeStatus) string {
+	master := ""
+	for _, node := range shard {
+		status := observed[node.Name]
+		if status.Role == dbv1beta1.RedisNodeRoleMaster && (master == "" || status.Slots > observed[master].Slots) {
+			master = node.Name
+		}
+	}
+
+	return master
+}
@@ -4,9 +4,13 @@ package controllers
 
 import (
 	"testing"
+	"time"
 
 	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
@@ -16,7 +20,7 @@ func TestRedisClusterIdentifyAction(t *testing.T) {
 			Spec: dbv1beta1.RedisClusterSpec{
 				Nodes: []dbv1beta1.RedisNodeSpec{
 					{
-						DiskSize: 1024,
+						DiskSize: resource.MustParse("1024Mi"),
 					},
 				},
 			},
@@ -45,7 +49,7 @@ func TestRedisClusterIdentifyAction(t *testing.T) {
 				Nodes: []dbv1beta1.RedisNodeStatus{
 					{
 						IP:       "1.2.3.4",
-						DiskSize: 1024,
+						DiskSize: resource.MustParse("1024Mi"),
 					},
 				},
 			},
@@ -58,15 +62,270 @@ func TestRedisClusterIdentifyAction(t *testing.T) {
 		require.IsType(t, &RemoveRedisNode{}, action)
 	})
 
+	t.Run("remove named node", func(t *testing.T) {
+		redisCluster := &dbv1beta1.RedisCluster{
+			Spec: dbv1beta1.RedisClusterSpec{
+				Nodes: []dbv1beta1.RedisNodeSpec{
+					{
+						Name:     "a",
+						DiskSize: resource.MustParse("1024Mi"),
+					},
+					{
+						Name:     "c",
+						DiskSize: resource.MustParse("1024Mi"),
+					},
+				},
+			},
+			Status: dbv1beta1.RedisClusterStatus{
+				Nodes: []dbv1beta1.RedisNodeStatus{
+					{
+						Name:     "a",
+						IP:       "1.2.3.4",
+						DiskSize: resource.MustParse("1024Mi"),
+						Ready:    true,
+						Joined:   true,
+					},
+					{
+						Name:     "b",
+						IP:       "1.2.3.5",
+						DiskSize: resource.MustParse("1024Mi"),
+						Ready:    true,
+						Joined:   true,
+					},
+					{
+						Name:     "c",
+						IP:       "1.2.3.6",
+						DiskSize: resource.MustParse("1024Mi"),
+						Ready:    true,
+						Joined:   true,
+					},
+				},
+			},
+		}
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &RemoveRedisNode{}, action)
+		require.Equal(t, "b", action.(*RemoveRedisNode).nodeName)
+	})
+
+	t.Run("reordered nodes", func(t *testing.T) {
+		redisCluster := &dbv1beta1.RedisCluster{
+			Spec: dbv1beta1.RedisClusterSpec{
+				Nodes: []dbv1beta1.RedisNodeSpec{
+					{
+						Name:     "b",
+						DiskSize: resource.MustParse("512Mi"),
+					},
+					{
+						Name:     "a",
+						DiskSize: resource.MustParse("1024Mi"),
+					},
+				},
+			},
+			Status: dbv1beta1.RedisClusterStatus{
+				Nodes: []dbv1beta1.RedisNodeStatus{
+					{
+						Name:     "a",
+						IP:       "1.2.3.4",
+						DiskSize: resource.MustParse("1024Mi"),
+						Ready:    true,
+						Joined:   true,
+					},
+					{
+						Name:     "b",
+						IP:       "1.2.3.5",
+						DiskSize: resource.MustParse("512Mi"),
+						Ready:    true,
+						Joined:   true,
+					},
+				},
+			},
+		}
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.Nil(t, action)
+	})
+
+	t.Run("equivalent disk sizes", func(t *testing.T) {
+		redisCluster := &dbv1beta1.RedisCluster{
+			Spec: dbv1beta1.RedisClusterSpec{
+				Nodes: []dbv1beta1.RedisNodeSpec{
+					{
+						DiskSize: resource.MustParse("1024Mi"),
+					},
+				},
+			},
+			Status: dbv1beta1.RedisClusterStatus{
+				Nodes: []dbv1beta1.RedisNodeStatus{
+					{
+						IP:       "1.2.3.4",
+						DiskSize: resource.MustParse("1Gi"),
+						Ready:    true,
+						Joined:   true,
+					},
+				},
+			},
+		}
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.Nil(t, action)
+	})
+
+	t.Run("add node via replicas", func(t *testing.T) {
+		replicas := int32(4)
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "0", IP: "1.2.3.4"},
+			dbv1beta1.RedisNodeStatus{Name: "1", IP: "1.2.3.5"},
+			dbv1beta1.RedisNodeStatus{Name: "2", IP: "1.2.3.6"},
+		)
+		redisCluster.Spec.ReplicasPerMaster = 0
+		redisCluster.Spec.Replicas = &replicas
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &AddRedisNode{}, action)
+	})
+
+	t.Run("remove node via replicas", func(t *testing.T) {
+		replicas := int32(3)
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "0", IP: "1.2.3.4"},
+			dbv1beta1.RedisNodeStatus{Name: "1", IP: "1.2.3.5"},
+			dbv1beta1.RedisNodeStatus{Name: "2", IP: "1.2.3.6"},
+			dbv1beta1.RedisNodeStatus{Name: "3", IP: "1.2.3.7"},
+		)
+		redisCluster.Spec.ReplicasPerMaster = 0
+		redisCluster.Spec.Replicas = &replicas
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &RemoveRedisNode{}, action)
+	})
+
+	t.Run("replicas below the minimum masters", func(t *testing.T) {
+		// the scale subresource isn't validated by the webhook
+		replicas := int32(4)
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "0", IP: "1.2.3.4", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "1", IP: "1.2.3.5", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "0"},
+			dbv1beta1.RedisNodeStatus{Name: "2", IP: "1.2.3.6", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "3", IP: "1.2.3.7", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "2"},
+			dbv1beta1.RedisNodeStatus{Name: "4", IP: "1.2.3.8", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "5", IP: "1.2.3.9", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "4"},
+		)
+		redisCluster.Spec.Replicas = &replicas
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.EqualError(t, err, "unable to scale to 4 nodes: a redis cluster requires at least 3 masters")
+		require.Nil(t, action)
+	})
+
+	t.Run("replicas not a multiple of the shard size", func(t *testing.T) {
+		replicas := int32(7)
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "0", IP: "1.2.3.4", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "1", IP: "1.2.3.5", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "0"},
+			dbv1beta1.RedisNodeStatus{Name: "2", IP: "1.2.3.6", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "3", IP: "1.2.3.7", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "2"},
+			dbv1beta1.RedisNodeStatus{Name: "4", IP: "1.2.3.8", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "5", IP: "1.2.3.9", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "4"},
+		)
+		redisCluster.Spec.Replicas = &replicas
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.EqualError(t, err, "unable to scale to 7 nodes: the number of nodes must be a multiple of replicasPerMaster+1")
+		require.Nil(t, action)
+	})
+
+	t.Run("await nodes", func(t *testing.T) {
+		redisCluster := &dbv1beta1.RedisCluster{
+			Spec: dbv1beta1.RedisClusterSpec{
+				Nodes: []dbv1beta1.RedisNodeSpec{
+					{
+						DiskSize: resource.MustParse("1024Mi"),
+					},
+				},
+			},
+			Status: dbv1beta1.RedisClusterStatus{
+				Nodes: []dbv1beta1.RedisNodeStatus{
+					{
+						DiskSize: resource.MustParse("1024Mi"),
+						// pod not yet ready
+					},
+				},
+			},
+		}
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &AwaitRedisNodes{}, action)
+	})
+
+	t.Run("bootstrap cluster", func(t *testing.T) {
+		redisCluster := &dbv1beta1.RedisCluster{
+			Spec: dbv1beta1.RedisClusterSpec{
+				Nodes: []dbv1beta1.RedisNodeSpec{
+					{
+						DiskSize: resource.MustParse("1024Mi"),
+					},
+					{
+						DiskSize: resource.MustParse("512Mi"),
+					},
+				},
+			},
+			Status: dbv1beta1.RedisClusterStatus{
+				Nodes: []dbv1beta1.RedisNodeStatus{
+					{
+						IP:       "1.2.3.4",
+						DiskSize: resource.MustParse("1024Mi"),
+						Ready:    true,
+						Joined:   true,
+					},
+					{
+						IP:       "1.2.3.5",
+						DiskSize: resource.MustParse("1024Mi"),
+						Ready:    true,
+						// not yet joined
+					},
+				},
+			},
+		}
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &BootstrapRedisCluster{}, action)
+	})
+
 	t.Run("update disk size", func(t *testing.T) {
 		redisCluster := &dbv1beta1.RedisCluster{
 			Spec: dbv1beta1.RedisClusterSpec{
 				Nodes: []dbv1beta1.RedisNodeSpec{
 					{
-						DiskSize: 1024,
+						DiskSize: resource.MustParse("1024Mi"),
 					},
 					{
-						DiskSize: 512,
+						DiskSize: resource.MustParse("512Mi"),
 					},
 				},
 			},
@@ -74,11 +333,15 @@ func TestRedisClusterIdentifyAction(t *testing.T) {
 				Nodes: []dbv1beta1.RedisNodeStatus{
 					{
 						IP:       "1.2.3.4",
-						DiskSize: 1024,
+						DiskSize: resource.MustParse("1024Mi"),
+						Ready:    true,
+						Joined:   true,
 					},
 					{
 						IP:       "1.2.3.5",
-						DiskSize: 1024,
+						DiskSize: resource.MustParse("1024Mi"),
+						Ready:    true,
+						Joined:   true,
 					},
 				},
 			},
@@ -92,12 +355,451 @@ func TestRedisClusterIdentifyAction(t *testing.T) {
 		require.Equal(t, 1, action.(*UpdateRedisNodeDiskSize).nodeIndex)
 	})
 
+	t.Run("replica missing", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "c"},
+		)
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &ReplicateRedisNode{}, action)
+		require.Equal(t, "b", action.(*ReplicateRedisNode).nodeName)
+		require.Equal(t, "a", action.(*ReplicateRedisNode).masterName)
+	})
+
+	t.Run("replica following the wrong master", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+		)
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &ReplicateRedisNode{}, action)
+		require.Equal(t, "c", action.(*ReplicateRedisNode).nodeName)
+		require.Equal(t, "d", action.(*ReplicateRedisNode).masterName)
+	})
+
+	t.Run("master demoted into a shard", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 4096},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 4097},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8191},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "c"},
+		)
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &ReplicateRedisNode{}, action)
+		require.Equal(t, "a", action.(*ReplicateRedisNode).nodeName)
+		require.Equal(t, "b", action.(*ReplicateRedisNode).masterName)
+	})
+
+	t.Run("shard without a master", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 16384},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+		)
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &DetachRedisNode{}, action)
+		require.Equal(t, "c", action.(*DetachRedisNode).nodeName)
+	})
+
+	t.Run("roles not yet observed", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "b"},
+			dbv1beta1.RedisNodeStatus{Name: "c"},
+			dbv1beta1.RedisNodeStatus{Name: "d"},
+		)
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.Nil(t, action)
+	})
+
+	t.Run("replicas following their masters", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "b"},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "c"},
+		)
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.Nil(t, action)
+	})
+
+	t.Run("replace failed node", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "c"},
+		)
+		redisCluster.Spec.Nodes[0].DiskSize = resource.MustParse("2Gi")
+		redisCluster.Status.Nodes[2].Ready = false
+		redisCluster.Status.Nodes[2].Failed = true
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &ReplaceRedisNode{}, action)
+		require.Equal(t, "c", action.(*ReplaceRedisNode).nodeName)
+	})
+
+	t.Run("failed node still ready", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "c", Failed: true},
+		)
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.Nil(t, action)
+	})
+
+	t.Run("configure replicas before masters", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "c"},
+		)
+		redisCluster.Spec.Config.Overrides = map[string]string{"slowlog-max-len": "256"}
+		redisCluster.Status.Nodes[1].ConfigHash = redisConfigHash(redisCluster)
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &ConfigureRedisNode{}, action)
+		require.Equal(t, "d", action.(*ConfigureRedisNode).nodeName)
+
+		redisCluster.Status.Nodes[3].ConfigHash = redisConfigHash(redisCluster)
+
+		action, err = actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &ConfigureRedisNode{}, action)
+		require.Equal(t, "a", action.(*ConfigureRedisNode).nodeName)
+	})
+
+	t.Run("rotate password", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "c"},
+		)
+		redisCluster.Spec.Auth = &dbv1beta1.RedisAuth{}
+		redisCluster.Status.AuthHash = redisAuthHash(redisCluster, "secret")
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &RotateRedisPassword{}, action)
+
+		// the rotation runs to completion once every node requires the new password
+		for i := range redisCluster.Status.Nodes {
+			redisCluster.Status.Nodes[i].AuthHash = redisCluster.Status.AuthHash
+		}
+		redisCluster.Status.PasswordRotation = &dbv1beta1.RedisPasswordRotation{
+			AuthHash: redisCluster.Status.AuthHash,
+			Phase:    dbv1beta1.RedisPasswordRotationGracePeriod,
+		}
+
+		action, err = actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &RotateRedisPassword{}, action)
+
+		redisCluster.Status.PasswordRotation = nil
+
+		action, err = actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.Nil(t, action)
+	})
+
+	t.Run("no persistence ignores disk sizes", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "c"},
+		)
+		redisCluster.Spec.Nodes[0].DiskSize = resource.MustParse("2Gi")
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &UpdateRedisNodeDiskSize{}, action)
+
+		// nodes without a pvc are observed without a disk size
+		redisCluster.Spec.Persistence.Mode = dbv1beta1.PersistenceModeNone
+		for i := range redisCluster.Status.Nodes {
+			redisCluster.Status.Nodes[i].DiskSize = resource.Quantity{}
+			redisCluster.Status.Nodes[i].ConfigHash = redisConfigHash(redisCluster)
+		}
+
+		action, err = actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.Nil(t, action)
+	})
+
+	t.Run("renew certificate", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "c"},
+		)
+		redisCluster.Spec.TLS = &dbv1beta1.RedisTLS{RenewBefore: &metav1.Duration{Duration: time.Hour}}
+		valid := metav1.NewTime(time.Now().Add(2 * time.Hour))
+		expiring := metav1.NewTime(time.Now().Add(time.Minute))
+		for i := range redisCluster.Status.Nodes {
+			redisCluster.Status.Nodes[i].ConfigHash = redisConfigHash(redisCluster)
+			redisCluster.Status.Nodes[i].CertificateNotAfter = &valid
+		}
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.Nil(t, action)
+
+		// replicas are restarted with a renewed certificate before masters
+		redisCluster.Status.Nodes[0].CertificateNotAfter = &expiring
+		redisCluster.Status.Nodes[3].CertificateNotAfter = &expiring
+
+		action, err = actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &RenewRedisNodeCertificate{}, action)
+		require.Equal(t, "d", action.(*RenewRedisNodeCertificate).nodeName)
+
+		redisCluster.Status.Nodes[3].CertificateNotAfter = &valid
+
+		action, err = actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &RenewRedisNodeCertificate{}, action)
+		require.Equal(t, "a", action.(*RenewRedisNodeCertificate).nodeName)
+	})
+
+	t.Run("generate password", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "c"},
+		)
+		redisCluster.Spec.Auth = &dbv1beta1.RedisAuth{}
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &GenerateRedisPassword{}, action)
+
+		// a referenced password is never generated
+		redisCluster.Spec.Auth.PasswordSecret = &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "password"},
+			Key:                  "password",
+		}
+
+		action, err = actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.Nil(t, action)
+	})
+
+	t.Run("rebalance new shard", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "c"},
+			dbv1beta1.RedisNodeStatus{Name: "e", Role: dbv1beta1.RedisNodeRoleMaster},
+			dbv1beta1.RedisNodeStatus{Name: "f", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "e"},
+		)
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &RebalanceRedisCluster{}, action)
+	})
+
+	t.Run("slots within rebalance threshold", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 5500},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 5500},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "c"},
+			dbv1beta1.RedisNodeStatus{Name: "e", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 5384},
+			dbv1beta1.RedisNodeStatus{Name: "f", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "e"},
+		)
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.Nil(t, action)
+
+		threshold := int32(1)
+		redisCluster.Spec.RebalanceThreshold = &threshold
+
+		action, err = actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &RebalanceRedisCluster{}, action)
+	})
+
+	t.Run("rebalance in progress", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 5500},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 5500},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "c"},
+			dbv1beta1.RedisNodeStatus{Name: "e", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 5384},
+			dbv1beta1.RedisNodeStatus{Name: "f", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "e"},
+		)
+		redisCluster.Status.Rebalance = &dbv1beta1.RedisRebalanceStatus{SlotsMoved: 5384, SlotsRemaining: 77}
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &RebalanceRedisCluster{}, action)
+	})
+
+	t.Run("fix open slots", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "c"},
+		)
+		// fixed ahead of adding a node
+		redisCluster.Spec.Nodes = append(redisCluster.Spec.Nodes, dbv1beta1.RedisNodeSpec{Name: "e", DiskSize: resource.MustParse("1Gi")})
+		redisCluster.Status.OpenSlots = 1
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &FixRedisCluster{}, action)
+	})
+
+	t.Run("fix uncovered slots", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8000},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "c"},
+		)
+		redisCluster.Status.UncoveredSlots = 192
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &FixRedisCluster{}, action)
+	})
+
+	t.Run("fix ahead of generating a password", func(t *testing.T) {
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+			dbv1beta1.RedisNodeStatus{Name: "c", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "d", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "c"},
+		)
+		redisCluster.Spec.Auth = &dbv1beta1.RedisAuth{}
+		redisCluster.Status.OpenSlots = 1
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &FixRedisCluster{}, action)
+	})
+
+	t.Run("teardown ahead of fix", func(t *testing.T) {
+		// nodes are deleted regardless, so a cluster whose nodes can't be reached can still be deleted
+		redisCluster := newShardedTestRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192},
+			dbv1beta1.RedisNodeStatus{Name: "b", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"},
+		)
+		deletionTimestamp := metav1.Now()
+		redisCluster.DeletionTimestamp = &deletionTimestamp
+		redisCluster.Finalizers = []string{redisClusterFinalizer}
+		redisCluster.Status.UncoveredSlots = 8192
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &TeardownRedisCluster{}, action)
+	})
+
+	t.Run("teardown", func(t *testing.T) {
+		deletionTimestamp := metav1.Now()
+		redisCluster := &dbv1beta1.RedisCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				DeletionTimestamp: &deletionTimestamp,
+				Finalizers:        []string{redisClusterFinalizer},
+			},
+			Spec: dbv1beta1.RedisClusterSpec{
+				Nodes: []dbv1beta1.RedisNodeSpec{
+					{
+						DiskSize: resource.MustParse("1024Mi"),
+					},
+				},
+			},
+			Status: dbv1beta1.RedisClusterStatus{
+				Nodes: []dbv1beta1.RedisNodeStatus{
+					// 0 nodes in status
+				},
+			},
+		}
+
+		actionIdentifier := NewRedisClusterActionIdentifier(nil, zap.Logger(true))
+
+		action, err := actionIdentifier.IdentifyAction(redisCluster)
+		require.NoError(t, err)
+		require.IsType(t, &TeardownRedisCluster{}, action)
+	})
+
 	t.Run("no action to take", func(t *testing.T) {
 		redisCluster := &dbv1beta1.RedisCluster{
 			Spec: dbv1beta1.RedisClusterSpec{
 				Nodes: []dbv1beta1.RedisNodeSpec{
 					{
-						DiskSize: 1024,
+						DiskSize: resource.MustParse("1024Mi"),
 					},
 				},
 			},
@@ -105,7 +807,9 @@ func TestRedisClusterIdentifyAction(t *testing.T) {
 				Nodes: []dbv1beta1.RedisNodeStatus{
 					{
 						IP:       "1.2.3.4",
-						DiskSize: 1024,
+						DiskSize: resource.MustParse("1024Mi"),
+						Ready:    true,
+						Joined:   true,
 					},
 				},
 			},
@@ -118,3 +822,31 @@ func TestRedisClusterIdentifyAction(t *testing.T) {
 		require.Nil(t, action)
 	})
 }
+
+// newShardedTestRedisCluster returns a cluster of two shards, each of a master and a replica,
+// whose nodes are all ready, joined and configured with the given statuses
+func newShardedTestRedisCluster(nodes ...dbv1beta1.RedisNodeStatus) *dbv1beta1.RedisCluster {
+	redisCluster := &dbv1beta1.RedisCluster{
+		Spec: dbv1beta1.RedisClusterSpec{
+			ReplicasPerMaster: 1,
+		},
+	}
+
+	for _, node := range nodes {
+		redisCluster.Spec.Nodes = append(redisCluster.Spec.Nodes, dbv1beta1.RedisNodeSpec{
+			Name:     node.Name,
+			DiskSize: resource.MustParse("1Gi"),
+		})
+
+		node.DiskSize = resource.MustParse("1Gi")
+		node.Ready = true
+		node.Joined = true
+		redisCluster.Status.Nodes = append(redisCluster.Status.Nodes, node)
+	}
+
+	for i := range redisCluster.Status.Nodes {
+		redisCluster.Status.Nodes[i].ConfigHash = redisConfigHash(redisCluster)
+	}
+
+	return redisCluster
+}
@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+	"github.com/eggsbenjamin/k8s_controller_experiment/pkg/redis"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type RedisClusterObserver struct {
+	k8sClient client.Client
+	log       logr.Logger
+}
+
+func NewRedisClusterObserver(k8sClient client.Client, log logr.Logger) Observer {
+	return &RedisClusterObserver{
+		k8sClient: k8sClient,
+		log:       log,
+	}
+}
+
+// Observe rebuilds the node statuses of a RedisCluster from the pods and PVCs that belong to it,
+// persisting the status if it has drifted from what was previously recorded
+func (o *RedisClusterObserver) Observe(obj runtime.Object) error {
+	redisCluster, ok := obj.(*dbv1beta1.RedisCluster)
+	if !ok {
+		return fmt.Errorf("unexpected runtime object: %#v", obj)
+	}
+
+	pods := &corev1.PodList{}
+	if err := o.k8sClient.List(context.TODO(), pods, client.InNamespace(redisCluster.Namespace), client.MatchingLabels(redisClusterLabels(redisCluster))); err != nil {
+		return err
+	}
+
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	if err := o.k8sClient.List(context.TODO(), pvcs, client.InNamespace(redisCluster.Namespace), client.MatchingLabels(redisClusterLabels(redisCluster))); err != nil {
+		return err
+	}
+
+	auth, err := redisClusterAuth(o.k8sClient, redisCluster)
+	if err != nil {
+		return err
+	}
+
+	authHash, err := o.observeAuthHash(redisCluster)
+	if err != nil {
+		return err
+	}
+
+	for i := range pods.Items {
+		if err := o.adopt(redisCluster, &pods.Items[i]); err != nil {
+			return err
+		}
+	}
+
+	pvcsByName := map[string]corev1.PersistentVolumeClaim{}
+	for i, pvc := range pvcs.Items {
+		if err := o.adopt(redisCluster, &pvcs.Items[i]); err != nil {
+			return err
+		}
+
+		pvcsByName[pvc.Name] = pvc
+	}
+
+	podsByName := map[string]corev1.Pod{}
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			continue // terminating
+		}
+
+		name := redisNodeName(&pod)
+		if name == "" {
+			o.log.Info("ignoring redis node pod without a node name label", "pod", pod.Name)
+			continue
+		}
+
+		podsByName[name] = pod
+	}
+
+	// nodes are ordered as in the spec, followed by any nodes being removed
+	names := []string{}
+	desired := map[string]bool{}
+	for _, node := range redisCluster.Spec.DesiredNodes() {
+		desired[node.Name] = true
+		if _, ok := podsByName[node.Name]; ok {
+			names = append(names, node.Name)
+		}
+	}
+
+	removed := []string{}
+	for name := range podsByName {
+		if !desired[name] {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	names = append(names, removed...)
+
+	previous := map[string]dbv1beta1.RedisNodeStatus{}
+	for i, node := range redisCluster.Status.Nodes {
+		previous[statusNodeName(i, node)] = node
+	}
+
+	nodes := []dbv1beta1.RedisNodeStatus{}
+	masterIDs := map[string]string{}
+	// nodes flagged as failing by any of the nodes that could be reached
+	failedIDs := map[string]bool{}
+	views := [][]redis.ClusterNode{}
+	// the own entry of every node that could be reached, which is authoritative for its open slots
+	reachable := []redis.ClusterNode{}
+	joined := false
+	for _, name := range names {
+		pod := podsByName[name]
+		node := dbv1beta1.RedisNodeStatus{
+			Name:      name,
+			IP:        pod.Status.PodIP,
+			Ready:     isPodReady(&pod),
+			StartTime: redisContainerStartTime(&pod),
+			// membership of the redis cluster can't be observed from k8s so is carried over,
+			// as is the node's role should redis be unreachable
+			NodeID:     previous[name].NodeID,
+			Joined:     previous[name].Joined,
+			Role:       previous[name].Role,
+			MasterName: previous[name].MasterName,
+			Slots:      previous[name].Slots,
+			Failed:     previous[name].Failed,
+			ConfigHash: pod.Annotations[redisConfigHashAnnotation],
+			AuthHash:   pod.Annotations[redisAuthHashAnnotation],
+		}
+
+		if pvc, ok := pvcsByName[redisNodePVCName(redisCluster, name)]; ok {
+			node.DiskSize = observedDiskSize(&pvc)
+		}
+
+		if node.Ready {
+			clusterNode, clusterNodes, err := observeRedisNode(auth, node.IP)
+			if err != nil {
+				o.log.Info("unable to observe redis node", "rediscluster", redisCluster.Name, "node", name, "error", err.Error())
+			} else {
+				// a node that has lost its identity, e.g. along with its data, needs to rejoin
+				if clusterNode.ID != node.NodeID && node.NodeID != "" {
+					node.Joined = false
+				}
+				node.NodeID = clusterNode.ID
+				node.Slots = int32(clusterNode.SlotCount())
+				node.Role = dbv1beta1.RedisNodeRoleMaster
+				if clusterNode.HasFlag("slave") {
+					node.Role = dbv1beta1.RedisNodeRoleReplica
+				}
+				masterIDs[name] = clusterNode.MasterID
+
+				views = append(views, clusterNodes)
+				reachable = append(reachable, clusterNode)
+
+				// only a certificate that's being served is recorded, so that a node isn't
+				// restarted again for a certificate it's yet to start with
+				if auth.tls != nil {
+					if notAfter, err := observeRedisNodeCertificate(auth, node.IP); err != nil {
+						o.log.Info("unable to observe redis node certificate", "rediscluster", redisCluster.Name, "node", name, "error", err.Error())
+					} else {
+						node.CertificateNotAfter = &metav1.Time{Time: notAfter}
+					}
+				}
+				for _, other := range clusterNodes {
+					if other.HasFlag("fail") {
+						failedIDs[other.ID] = true
+					}
+				}
+			}
+		}
+
+		joined = joined || node.Joined
+		nodes = append(nodes, node)
+	}
+
+	// masters are identified by name, which is only known once every node id has been observed
+	nodeNames := map[string]string{}
+	for _, node := range nodes {
+		if node.NodeID != "" {
+			nodeNames[node.NodeID] = node.Name
+		}
+	}
+	for i := range nodes {
+		if masterID, ok := masterIDs[nodes[i].Name]; ok {
+			nodes[i].MasterName = nodeNames[masterID]
+		}
+		if len(views) > 0 {
+			nodes[i].Failed = nodes[i].NodeID != "" && failedIDs[nodes[i].NodeID]
+		}
+	}
+
+	// slots are unassigned until a new cluster has been bootstrapped, so coverage is only
+	// checked once nodes have joined
+	open := int32(len(openSlots(reachable)))
+	uncovered := int32(0)
+	if joined && len(views) > 0 {
+		uncovered = int32(len(uncoveredSlots(views)))
+	}
+
+	replicas := int32(len(nodes))
+	selector := labels.SelectorFromSet(redisClusterLabels(redisCluster)).String()
+
+	status := redisCluster.Status
+	if equality.Semantic.DeepEqual(nodes, status.Nodes) && replicas == status.Replicas && selector == status.Selector && open == status.OpenSlots && uncovered == status.UncoveredSlots && authHash == status.AuthHash {
+		return nil
+	}
+
+	return updateStatus(o.k8sClient, redisCluster, func(redisCluster *dbv1beta1.RedisCluster) {
+		redisCluster.Status.Nodes = nodes
+		redisCluster.Status.Replicas = replicas
+		redisCluster.Status.Selector = selector
+		redisCluster.Status.OpenSlots = open
+		redisCluster.Status.UncoveredSlots = uncovered
+		redisCluster.Status.AuthHash = authHash
+	})
+}
+
+// observeAuthHash returns the hash of the password the nodes of a RedisCluster should
+// require, or "" if the password is yet to be generated or isn't required
+func (o *RedisClusterObserver) observeAuthHash(redisCluster *dbv1beta1.RedisCluster) (string, error) {
+	password, err := desiredRedisPassword(o.k8sClient, redisCluster)
+	if err != nil {
+		switch {
+		case k8serrors.IsNotFound(err) && redisCluster.Spec.Auth.PasswordSecret == nil:
+			return "", nil
+		case redisCluster.DeletionTimestamp != nil:
+			// a missing password mustn't stop the cluster from being torn down
+			return redisCluster.Status.AuthHash, nil
+		}
+
+		return "", fmt.Errorf("unable to read the password of redis cluster %s: %s", redisCluster.Name, err)
+	}
+
+	return redisAuthHash(redisCluster, password), nil
+}
+
+// adopt sets a controller owner reference to the RedisCluster on a child that was created
+// without one, e.g. by an earlier version of the controller
+func (o *RedisClusterObserver) adopt(redisCluster *dbv1beta1.RedisCluster, child runtime.Object) error {
+	meta, err := apimeta.Accessor(child)
+	if err != nil {
+		return err
+	}
+
+	if metav1.GetControllerOf(meta) != nil || meta.GetDeletionTimestamp() != nil || redisCluster.DeletionTimestamp != nil {
+		return nil
+	}
+
+	meta.SetOwnerReferences(append(meta.GetOwnerReferences(), redisClusterOwnerReference(redisCluster)))
+	o.log.Info("adopting redis node resource", "rediscluster", redisCluster.Name, "name", meta.GetName())
+
+	return o.k8sClient.Update(context.TODO(), child)
+}
+
+// observedDiskSize returns the capacity of a PVC. Volumes are often provisioned larger than
+// requested, so the capacity is capped at the requested size.
+func observedDiskSize(pvc *corev1.PersistentVolumeClaim) resource.Quantity {
+	capacity := pvc.Status.Capacity[corev1.ResourceStorage]
+	requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if capacity.Cmp(requested) > 0 {
+		return requested.DeepCopy()
+	}
+
+	return capacity.DeepCopy()
+}
@@ -0,0 +1,437 @@
+package controllers
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+	"github.com/eggsbenjamin/k8s_controller_experiment/pkg/redis"
+)
+
+const (
+	redisTimeout      = 5 * time.Second
+	migrateBatchSize  = 100
+	migrateKeyTimeout = 5 * time.Second
+)
+
+// dialRedisNode connects to the redis node listening on the given pod IP, over TLS if the
+// cluster uses it, authenticating with the first of the cluster's passwords that the node
+// accepts
+func dialRedisNode(auth redisAuth, ip string) (*redis.Client, error) {
+	addr := net.JoinHostPort(ip, strconv.Itoa(redisPort))
+	var client *redis.Client
+	var err error
+	if auth.tls != nil {
+		client, err = redis.DialTLS(addr, redisTimeout, auth.tls)
+	} else {
+		client, err = redis.Dial(addr, redisTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := auth.authenticate(client); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// migrateSlot moves a hash slot, and every key stored in it, from one master to another
+// following the same sequence as redis-cli --cluster reshard
+func migrateSlot(source, destination *redis.Client, sourceID, destinationID, destinationIP string, slot int) error {
+	if err := destination.ClusterSetSlotImporting(slot, sourceID); err != nil {
+		return err
+	}
+
+	if err := source.ClusterSetSlotMigrating(slot, destinationID); err != nil {
+		return err
+	}
+
+	if err := migrateKeys(source, destinationIP, destination.Password(), slot); err != nil {
+		return err
+	}
+
+	if err := destination.ClusterSetSlotNode(slot, destinationID); err != nil {
+		return err
+	}
+
+	return source.ClusterSetSlotNode(slot, destinationID)
+}
+
+// migrateKeys moves every key stored in a slot on the source node to the node listening on
+// the destination pod IP, which requires the given password, in batches of migrateBatchSize
+// keys. The slot must be open, i.e. migrating or importing, on the source for it to accept
+// the MIGRATE.
+func migrateKeys(source *redis.Client, destinationIP, destinationPassword string, slot int) error {
+	for {
+		keys, err := source.ClusterGetKeysInSlot(slot, migrateBatchSize)
+		if err != nil {
+			return err
+		}
+
+		if len(keys) == 0 {
+			return nil
+		}
+
+		if err := source.Migrate(destinationIP, redisPort, destinationPassword, migrateKeyTimeout, keys...); err != nil {
+			return err
+		}
+	}
+}
+
+// isSlotOpen reports whether the given node has a slot migrating or importing
+func isSlotOpen(node redis.ClusterNode, slot int) bool {
+	_, importing := node.Importing[slot]
+	_, migrating := node.Migrating[slot]
+	return importing || migrating
+}
+
+// setSlotStable clears the migrating or importing state of a slot on the given node, if any
+func setSlotStable(auth redisAuth, node redis.ClusterNode, slot int) error {
+	if !isSlotOpen(node, slot) {
+		return nil
+	}
+
+	client, err := dialRedisNode(auth, node.IP)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.ClusterSetSlotStable(slot)
+}
+
+// forgetRedisNode removes a node from the node table of the redis node listening on the
+// given pod IP. Nodes that never knew about the forgotten node are left untouched.
+func forgetRedisNode(auth redisAuth, ip, nodeID string) error {
+	client, err := dialRedisNode(auth, ip)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.ClusterForget(nodeID); err != nil && !strings.Contains(err.Error(), "Unknown node") {
+		return err
+	}
+
+	return nil
+}
+
+// replicateRedisNode makes the redis node listening on the given pod IP a replica of the given master
+func replicateRedisNode(auth redisAuth, ip, masterID string) error {
+	client, err := dialRedisNode(auth, ip)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.ClusterReplicate(masterID)
+}
+
+// setRedisUser creates or replaces an ACL user on the redis node listening on the given pod IP
+func setRedisUser(auth redisAuth, ip, username string, rules []string) error {
+	client, err := dialRedisNode(auth, ip)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.ACLSetUser(username, rules...)
+}
+
+// setRedisConfig sets a parameter at runtime on the redis node listening on the given pod IP
+func setRedisConfig(auth redisAuth, ip, parameter, value string) error {
+	client, err := dialRedisNode(auth, ip)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.ConfigSet(parameter, value)
+}
+
+// deleteRedisUser deletes an ACL user from the redis node listening on the given pod IP
+func deleteRedisUser(auth redisAuth, ip, username string) error {
+	client, err := dialRedisNode(auth, ip)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.ACLDelUser(username)
+}
+
+// assignSlots spreads the hash slots of a new cluster evenly across its nodes, in node order.
+// Slots that are already served are skipped so that an interrupted assignment can be resumed.
+func assignSlots(auth redisAuth, nodes []dbv1beta1.RedisNodeStatus, clusterNodes []redis.ClusterNode) error {
+	served := map[int]bool{}
+	for _, node := range clusterNodes {
+		for _, slot := range node.SlotList() {
+			served[slot] = true
+		}
+	}
+
+	for i, node := range nodes {
+		slots := []int{}
+		for slot := i * redis.ClusterSlots / len(nodes); slot < (i+1)*redis.ClusterSlots/len(nodes); slot++ {
+			if !served[slot] {
+				slots = append(slots, slot)
+			}
+		}
+
+		if len(slots) == 0 {
+			continue
+		}
+
+		client, err := dialRedisNode(auth, node.IP)
+		if err != nil {
+			return err
+		}
+
+		err = client.ClusterAddSlots(slots...)
+		client.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// openSlots returns the slots that any of the given nodes has left migrating or importing,
+// in ascending order. Only a node's own entry is authoritative for its open slots.
+func openSlots(nodes []redis.ClusterNode) []int {
+	open := map[int]bool{}
+	for _, node := range nodes {
+		for slot := range node.Migrating {
+			open[slot] = true
+		}
+		for slot := range node.Importing {
+			open[slot] = true
+		}
+	}
+
+	slots := make([]int, 0, len(open))
+	for slot := range open {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+
+	return slots
+}
+
+// uncoveredSlots returns the slots that no node is serving according to any of the given
+// views of the cluster, in ascending order
+func uncoveredSlots(views [][]redis.ClusterNode) []int {
+	covered := make([]bool, redis.ClusterSlots)
+	for _, view := range views {
+		for _, node := range view {
+			for _, r := range node.Slots {
+				for slot := r.Start; slot <= r.End; slot++ {
+					covered[slot] = true
+				}
+			}
+		}
+	}
+
+	slots := []int{}
+	for slot, ok := range covered {
+		if !ok {
+			slots = append(slots, slot)
+		}
+	}
+
+	return slots
+}
+
+// slotShare returns the fewest and most slots each of the given number of masters serves
+// when the slots are spread as evenly as possible
+func slotShare(masters int) (int, int) {
+	lower := redis.ClusterSlots / masters
+	if redis.ClusterSlots%masters == 0 {
+		return lower, lower
+	}
+
+	return lower, lower + 1
+}
+
+// isSlotDistributionBalanced reports whether the number of slots served by every one of the
+// given masters is within threshold percent of an even share
+func isSlotDistributionBalanced(slots []int, threshold int32) bool {
+	if len(slots) == 0 {
+		return true
+	}
+
+	lower, upper := slotShare(len(slots))
+	for _, count := range slots {
+		deviation := 0
+		if count < lower {
+			deviation = lower - count
+		} else if count > upper {
+			deviation = count - upper
+		}
+
+		if deviation*100 > lower*int(threshold) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// slotMove is the migration of a single slot between two masters
+type slotMove struct {
+	slot        int
+	source      redis.ClusterNode
+	destination redis.ClusterNode
+}
+
+// planRebalance returns up to limit slot migrations that bring the given masters closer to an
+// even distribution of slots, along with the number of slots left to move once they're done.
+// The masters already serving the most slots keep the larger shares so that as few slots as
+// possible move, and slots are taken from the end of each source's ranges to keep them
+// contiguous.
+func planRebalance(masters []redis.ClusterNode, limit int) ([]slotMove, int) {
+	if len(masters) == 0 {
+		return nil, 0
+	}
+
+	sorted := make([]redis.ClusterNode, len(masters))
+	copy(sorted, masters)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].SlotCount() > sorted[j].SlotCount()
+	})
+
+	lower, upper := slotShare(len(sorted))
+	larger := redis.ClusterSlots - lower*len(sorted)
+
+	type surplus struct {
+		node  redis.ClusterNode
+		slots []int
+	}
+	sources := []surplus{}
+	destinations := []redis.ClusterNode{}
+	deficits := []int{}
+	remaining := 0
+	for i, master := range sorted {
+		target := lower
+		if i < larger {
+			target = upper
+		}
+
+		count := master.SlotCount()
+		if count > target {
+			slots := master.SlotList()
+			sources = append(sources, surplus{node: master, slots: slots[target:]})
+			remaining += count - target
+		} else if count < target {
+			destinations = append(destinations, master)
+			deficits = append(deficits, target-count)
+		}
+	}
+
+	moves := []slotMove{}
+	for _, source := range sources {
+		for k := len(source.slots) - 1; k >= 0 && len(moves) < limit && len(destinations) > 0; k-- {
+			moves = append(moves, slotMove{slot: source.slots[k], source: source.node, destination: destinations[0]})
+
+			if deficits[0]--; deficits[0] == 0 {
+				destinations, deficits = destinations[1:], deficits[1:]
+			}
+		}
+	}
+
+	return moves, remaining - len(moves)
+}
+
+// staleClusterNodes returns the ids of failed nodes that aren't among the given nodes, such
+// as the previous identity of a node that was recreated without its data
+func staleClusterNodes(clusterNodes []redis.ClusterNode, nodes []dbv1beta1.RedisNodeStatus) []string {
+	current := map[string]bool{}
+	for _, node := range nodes {
+		if node.NodeID != "" {
+			current[node.NodeID] = true
+		}
+	}
+
+	stale := []string{}
+	for _, clusterNode := range clusterNodes {
+		if current[clusterNode.ID] || clusterNode.HasFlag("myself") {
+			continue
+		}
+		if clusterNode.HasFlag("fail") || clusterNode.HasFlag("noaddr") {
+			stale = append(stale, clusterNode.ID)
+		}
+	}
+
+	return stale
+}
+
+// isClusterStateOK reports whether the redis node listening on the given pod IP knows
+// about at least the expected number of nodes and considers the cluster healthy. Nodes that
+// have yet to forget a stale node may know of more.
+func isClusterStateOK(auth redisAuth, ip string, expectedNodes int) (bool, error) {
+	client, err := dialRedisNode(auth, ip)
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+
+	info, err := client.ClusterInfo()
+	if err != nil {
+		return false, err
+	}
+
+	known, err := strconv.Atoi(info["cluster_known_nodes"])
+	if err != nil {
+		return false, fmt.Errorf("invalid cluster_known_nodes %q: %s", info["cluster_known_nodes"], err)
+	}
+
+	return info["cluster_state"] == "ok" && known >= expectedNodes, nil
+}
+
+// observeRedisNode returns the cluster as seen by the redis node listening on the given pod
+// IP, along with the node's own entry, which is authoritative for its id, role and slots
+func observeRedisNode(auth redisAuth, ip string) (redis.ClusterNode, []redis.ClusterNode, error) {
+	client, err := dialRedisNode(auth, ip)
+	if err != nil {
+		return redis.ClusterNode{}, nil, err
+	}
+	defer client.Close()
+
+	clusterNodes, err := client.ClusterNodes()
+	if err != nil {
+		return redis.ClusterNode{}, nil, err
+	}
+
+	for _, node := range clusterNodes {
+		if node.HasFlag("myself") {
+			return node, clusterNodes, nil
+		}
+	}
+
+	return redis.ClusterNode{}, nil, fmt.Errorf("node %s is missing from its own cluster nodes", ip)
+}
+
+// observeRedisNodeCertificate returns when the certificate served by the node listening on
+// the given pod IP expires
+func observeRedisNodeCertificate(auth redisAuth, ip string) (time.Time, error) {
+	client, err := dialRedisNode(auth, ip)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer client.Close()
+
+	certificate := client.PeerCertificate()
+	if certificate == nil {
+		return time.Time{}, fmt.Errorf("node %s presented no certificate", ip)
+	}
+
+	return certificate.NotAfter, nil
+}
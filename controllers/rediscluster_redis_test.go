@@ -0,0 +1,111 @@
+// +build unit
+
+package controllers
+
+import (
+	"testing"
+
+	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+	"github.com/eggsbenjamin/k8s_controller_experiment/pkg/redis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanRebalance(t *testing.T) {
+	t.Run("new master", func(t *testing.T) {
+		masters := []redis.ClusterNode{
+			{ID: "a", Slots: []redis.SlotRange{{Start: 0, End: 8191}}},
+			{ID: "b", Slots: []redis.SlotRange{{Start: 8192, End: 16383}}},
+			{ID: "c"},
+		}
+
+		moves, remaining := planRebalance(masters, 16)
+		require.Len(t, moves, 16)
+		require.Equal(t, 5461-16, remaining)
+		for _, move := range moves {
+			require.Equal(t, "a", move.source.ID)
+			require.Equal(t, "c", move.destination.ID)
+		}
+		require.Equal(t, 8191, moves[0].slot)
+
+		moves, remaining = planRebalance(masters, redis.ClusterSlots)
+		require.Len(t, moves, 5461)
+		require.Equal(t, 0, remaining)
+
+		moved := map[string]int{}
+		for _, move := range moves {
+			moved[move.source.ID]--
+			moved[move.destination.ID]++
+		}
+		require.Equal(t, -2730, moved["a"])
+		require.Equal(t, -2731, moved["b"])
+		require.Equal(t, 5461, moved["c"])
+	})
+
+	t.Run("balanced", func(t *testing.T) {
+		masters := []redis.ClusterNode{
+			{ID: "a", Slots: []redis.SlotRange{{Start: 0, End: 5460}}},
+			{ID: "b", Slots: []redis.SlotRange{{Start: 5461, End: 10922}}},
+			{ID: "c", Slots: []redis.SlotRange{{Start: 10923, End: 16383}}},
+		}
+
+		moves, remaining := planRebalance(masters, 16)
+		require.Empty(t, moves)
+		require.Equal(t, 0, remaining)
+	})
+}
+
+func TestIsSlotDistributionBalanced(t *testing.T) {
+	require.True(t, isSlotDistributionBalanced([]int{5461, 5462, 5461}, 0))
+	require.True(t, isSlotDistributionBalanced([]int{5500, 5500, 5384}, 2))
+	require.False(t, isSlotDistributionBalanced([]int{5500, 5500, 5384}, 1))
+	require.False(t, isSlotDistributionBalanced([]int{8192, 8192, 0}, 50))
+	require.True(t, isSlotDistributionBalanced(nil, 2))
+}
+
+func TestOpenSlots(t *testing.T) {
+	nodes := []redis.ClusterNode{
+		{ID: "a", Migrating: map[int]string{10: "b", 3: "b"}},
+		{ID: "b", Importing: map[int]string{10: "a"}},
+		{ID: "c", Importing: map[int]string{7: "a"}},
+	}
+
+	require.Equal(t, []int{3, 7, 10}, openSlots(nodes))
+	require.Empty(t, openSlots([]redis.ClusterNode{{ID: "a"}}))
+}
+
+func TestUncoveredSlots(t *testing.T) {
+	views := [][]redis.ClusterNode{
+		{
+			{ID: "a", Slots: []redis.SlotRange{{Start: 0, End: 8191}}},
+			{ID: "b", Slots: []redis.SlotRange{{Start: 8192, End: 16379}}},
+		},
+		{
+			// only this view knows that b serves 16380
+			{ID: "a", Slots: []redis.SlotRange{{Start: 0, End: 8191}}},
+			{ID: "b", Slots: []redis.SlotRange{{Start: 8192, End: 16380}}},
+		},
+	}
+
+	require.Equal(t, []int{16381, 16382, 16383}, uncoveredSlots(views))
+}
+
+func TestStaleClusterNodes(t *testing.T) {
+	clusterNodes := []redis.ClusterNode{
+		{ID: "a", Flags: []string{"myself", "master"}},
+		{ID: "b", Flags: []string{"slave"}},
+		// b's identity before its pod was recreated without its data
+		{ID: "old-b", Flags: []string{"slave", "fail"}},
+		{ID: "gone", Flags: []string{"master", "fail", "noaddr"}},
+		// failed, but still one of the cluster's nodes so left for ReplaceRedisNode
+		{ID: "c", Flags: []string{"master", "fail"}},
+	}
+	nodes := []dbv1beta1.RedisNodeStatus{
+		{Name: "a", NodeID: "a"},
+		{Name: "b", NodeID: "b"},
+		{Name: "c", NodeID: "c"},
+		{Name: "d"},
+	}
+
+	require.Equal(t, []string{"old-b", "gone"}, staleClusterNodes(clusterNodes, nodes))
+	require.Empty(t, staleClusterNodes(clusterNodes[:2], nodes))
+}
@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	redisClusterFinalizer = "db.k8s.io/teardown"
+	// redisNodeReplacementDelay is how long the pod of a failed node must be unready before
+	// the node is replaced, so that brief outages don't cost a node its data
+	redisNodeReplacementDelay = 5 * time.Minute
+	// rebalanceBatchSlots is the number of slots migrated by each pass of a rebalance, which
+	// are separated by rebalanceBatchInterval
+	rebalanceBatchSlots    = 16
+	rebalanceBatchInterval = time.Second
+
+	redisPort         = 6379
+	redisBusPort      = 16379
+	redisDataVolume   = "data"
+	redisDataPath     = "/data"
+	redisClusterLabel = "db.k8s.io/rediscluster"
+	redisNodeLabel    = "db.k8s.io/redis-node"
+	// redisNodeIndexLabel identifies the nodes of clusters created before nodes were named
+	redisNodeIndexLabel = "db.k8s.io/redis-node-index"
+)
+
+// redisClusterLabels returns the labels shared by every resource belonging to a RedisCluster
+func redisClusterLabels(redisCluster *dbv1beta1.RedisCluster) map[string]string {
+	return map[string]string{
+		redisClusterLabel: redisCluster.Name,
+	}
+}
+
+// redisNodeLabels returns the labels identifying the resources of a single redis node
+func redisNodeLabels(redisCluster *dbv1beta1.RedisCluster, nodeName string) map[string]string {
+	labels := redisClusterLabels(redisCluster)
+	labels[redisNodeLabel] = nodeName
+	return labels
+}
+
+// redisNodeName returns the name of the node a pod or PVC belongs to
+func redisNodeName(meta metav1.Object) string {
+	if name, ok := meta.GetLabels()[redisNodeLabel]; ok {
+		return name
+	}
+
+	return meta.GetLabels()[redisNodeIndexLabel]
+}
+
+// statusNodeName returns the name of the node at the given index of the status. Nodes
+// recorded before nodes were named are identified by their index.
+func statusNodeName(index int, node dbv1beta1.RedisNodeStatus) string {
+	if node.Name != "" {
+		return node.Name
+	}
+
+	return strconv.Itoa(index)
+}
+
+// statusNodesByName returns the node statuses of a RedisCluster keyed by node name
+func statusNodesByName(redisCluster *dbv1beta1.RedisCluster) map[string]dbv1beta1.RedisNodeStatus {
+	nodes := map[string]dbv1beta1.RedisNodeStatus{}
+	for i, node := range redisCluster.Status.Nodes {
+		nodes[statusNodeName(i, node)] = node
+	}
+
+	return nodes
+}
+
+// redisClusterOwnerReference returns a controller owner reference to the given RedisCluster so
+// that its children are garbage collected with it and changes to them trigger a reconcile
+func redisClusterOwnerReference(redisCluster *dbv1beta1.RedisCluster) metav1.OwnerReference {
+	return *metav1.NewControllerRef(redisCluster, dbv1beta1.GroupVersion.WithKind("RedisCluster"))
+}
+
+func redisNodePodName(redisCluster *dbv1beta1.RedisCluster, nodeName string) string {
+	return fmt.Sprintf("%s-%s", redisCluster.Name, nodeName)
+}
+
+func redisNodePVCName(redisCluster *dbv1beta1.RedisCluster, nodeName string) string {
+	return fmt.Sprintf("%s-%s-%s", redisCluster.Name, nodeName, redisDataVolume)
+}
+
+func newRedisNodePVC(redisCluster *dbv1beta1.RedisCluster, node dbv1beta1.RedisNodeSpec) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            redisNodePVCName(redisCluster, node.Name),
+			Namespace:       redisCluster.Namespace,
+			Labels:          redisNodeLabels(redisCluster, node.Name),
+			OwnerReferences: []metav1.OwnerReference{redisClusterOwnerReference(redisCluster)},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			StorageClassName: redisCluster.Spec.StorageClassName,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: node.DiskSize,
+				},
+			},
+		},
+	}
+}
+
+// redisClusterImage returns the image run by a cluster's nodes, falling back to the default
+// for clusters stored before the image was defaulted
+func redisClusterImage(redisCluster *dbv1beta1.RedisCluster) string {
+	if redisCluster.Spec.Image != "" {
+		return redisCluster.Spec.Image
+	}
+
+	return dbv1beta1.DefaultImage
+}
+
+func newRedisNodePod(redisCluster *dbv1beta1.RedisCluster, node dbv1beta1.RedisNodeSpec) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            redisNodePodName(redisCluster, node.Name),
+			Namespace:       redisCluster.Namespace,
+			Labels:          redisNodeLabels(redisCluster, node.Name),
+			OwnerReferences: []metav1.OwnerReference{redisClusterOwnerReference(redisCluster)},
+			Annotations: map[string]string{
+				redisConfigHashAnnotation:       redisConfigHash(redisCluster),
+				redisStaticConfigHashAnnotation: redisStaticConfigHash(redisCluster),
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:      "redis",
+					Image:     redisClusterImage(redisCluster),
+					Command:   []string{"redis-server", redisConfigPath + "/" + redisConfigFile},
+					Resources: *redisCluster.Spec.Resources.DeepCopy(),
+					Ports: []corev1.ContainerPort{
+						{Name: "client", ContainerPort: redisPort},
+						{Name: "bus", ContainerPort: redisBusPort},
+					},
+					ReadinessProbe: &corev1.Probe{
+						Handler: corev1.Handler{
+							TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(redisPort)},
+						},
+						PeriodSeconds: 5,
+					},
+					VolumeMounts: []corev1.VolumeMount{
+						{Name: redisDataVolume, MountPath: redisDataPath},
+						{Name: redisConfigVolume, MountPath: redisConfigPath, ReadOnly: true},
+						{Name: redisAuthVolume, MountPath: redisAuthPath, ReadOnly: true},
+					},
+				},
+			},
+			Volumes: []corev1.Volume{
+				{
+					Name: redisDataVolume,
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+							ClaimName: redisNodePVCName(redisCluster, node.Name),
+						},
+					},
+				},
+				{
+					Name: redisConfigVolume,
+					VolumeSource: corev1.VolumeSource{
+						ConfigMap: &corev1.ConfigMapVolumeSource{
+							LocalObjectReference: corev1.LocalObjectReference{Name: redisConfigMapName(redisCluster)},
+						},
+					},
+				},
+				{
+					Name: redisAuthVolume,
+					VolumeSource: corev1.VolumeSource{
+						Secret: &corev1.SecretVolumeSource{
+							SecretName: redisAuthSecretName(redisCluster),
+							Items:      []corev1.KeyToPath{{Key: redisAuthFile, Path: redisAuthFile}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// nodes that don't persist their data only need somewhere to keep nodes.conf
+	if redisCluster.Spec.Persistence.Mode == dbv1beta1.PersistenceModeNone {
+		pod.Spec.Volumes[0].VolumeSource = corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+	}
+
+	if redisCluster.Spec.TLS != nil {
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name: redisTLSVolume, MountPath: redisTLSPath, ReadOnly: true,
+		})
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: redisTLSVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: redisNodeTLSSecretName(redisCluster, node.Name),
+					Items: []corev1.KeyToPath{
+						{Key: corev1.TLSCertKey, Path: corev1.TLSCertKey},
+						{Key: corev1.TLSPrivateKeyKey, Path: corev1.TLSPrivateKeyKey},
+						{Key: redisCACertificateKey, Path: redisCACertificateKey},
+					},
+				},
+			},
+		})
+	}
+
+	return pod
+}
+
+// isPodReady reports whether a pod is running, has been assigned an IP and is passing its readiness probe
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+		return false
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// redisContainerStartTime returns when the redis container of a pod last started, or nil if
+// it isn't running
+func redisContainerStartTime(pod *corev1.Pod) *metav1.Time {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == "redis" && status.State.Running != nil {
+			startedAt := status.State.Running.StartedAt
+			return &startedAt
+		}
+	}
+
+	return nil
+}
+
+// rebalanceThreshold returns the percentage by which a master's share of the slots may differ
+// from an even share before the cluster is rebalanced
+func rebalanceThreshold(redisCluster *dbv1beta1.RedisCluster) int32 {
+	if redisCluster.Spec.RebalanceThreshold == nil {
+		return dbv1beta1.DefaultRebalanceThreshold
+	}
+
+	return *redisCluster.Spec.RebalanceThreshold
+}
+
+// podUnreadySince returns when the given pod last stopped being ready, or when it was created
+// if it has never been ready
+func podUnreadySince(pod *corev1.Pod) time.Time {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady && condition.Status != corev1.ConditionTrue {
+			return condition.LastTransitionTime.Time
+		}
+	}
+
+	return pod.CreationTimestamp.Time
+}
+
+// isPVCResizing reports whether a volume expansion of the given PVC is still in progress
+func isPVCResizing(pvc *corev1.PersistentVolumeClaim) bool {
+	for _, condition := range pvc.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+
+		if condition.Type == corev1.PersistentVolumeClaimResizing || condition.Type == corev1.PersistentVolumeClaimFileSystemResizePending {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func removeString(slice []string, s string) []string {
+	result := []string{}
+	for _, item := range slice {
+		if item != s {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,36 @@
+// +build unit
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRedisContainerStartTime(t *testing.T) {
+	startedAt := metav1.NewTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	newPod := func(statuses ...corev1.ContainerStatus) *corev1.Pod {
+		return &corev1.Pod{Status: corev1.PodStatus{ContainerStatuses: statuses}}
+	}
+
+	t.Run("running", func(t *testing.T) {
+		pod := newPod(
+			corev1.ContainerStatus{Name: "sidecar", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			corev1.ContainerStatus{Name: "redis", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: startedAt}}},
+		)
+		require.Equal(t, &startedAt, redisContainerStartTime(pod))
+	})
+
+	t.Run("waiting to restart", func(t *testing.T) {
+		pod := newPod(corev1.ContainerStatus{Name: "redis", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}})
+		require.Nil(t, redisContainerStartTime(pod))
+	})
+
+	t.Run("not yet created", func(t *testing.T) {
+		require.Nil(t, redisContainerStartTime(newPod()))
+	})
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// actionName returns the type name of an action, e.g. AddRedisNode, for use in condition reasons
+func actionName(action Action) string {
+	if action == nil {
+		return ""
+	}
+
+	t := reflect.TypeOf(action)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t.Name()
+}
+
+// updateConditions sets the status conditions and observed generation of a RedisCluster
+// from the action chosen during reconciliation and the error it returned, if any
+func updateConditions(redisCluster *dbv1beta1.RedisCluster, action Action, err error) {
+	status := &redisCluster.Status
+	status.ObservedGeneration = redisCluster.Generation
+
+	notReady := 0
+	for _, node := range status.Nodes {
+		if !node.Ready {
+			notReady++
+		}
+	}
+
+	if notReady > 0 {
+		status.SetCondition(dbv1beta1.RedisClusterDegraded, corev1.ConditionTrue, "NodesNotReady", fmt.Sprintf("%d of %d nodes are not ready", notReady, len(status.Nodes)))
+	} else {
+		status.SetCondition(dbv1beta1.RedisClusterDegraded, corev1.ConditionFalse, "NodesReady", "")
+	}
+
+	switch rotation := status.PasswordRotation; {
+	case rotation == nil:
+		status.SetCondition(dbv1beta1.RedisClusterPasswordRotating, corev1.ConditionFalse, "PasswordCurrent", "")
+	case rotation.Phase == dbv1beta1.RedisPasswordRotationGracePeriod && rotation.GracePeriodStartTime != nil:
+		until := rotation.GracePeriodStartTime.Add(rotationGracePeriod(redisCluster)).UTC().Format(time.RFC3339)
+		status.SetCondition(dbv1beta1.RedisClusterPasswordRotating, corev1.ConditionTrue, string(rotation.Phase), fmt.Sprintf("nodes accept both the old and the new password until %s", until))
+	default:
+		status.SetCondition(dbv1beta1.RedisClusterPasswordRotating, corev1.ConditionTrue, string(rotation.Phase), "adding the new password to every node alongside the old one")
+	}
+
+	if _, requeue := err.(*RequeueError); err != nil && !requeue {
+		status.SetCondition(dbv1beta1.RedisClusterReconcileError, corev1.ConditionTrue, "ReconcileFailed", err.Error())
+	} else {
+		status.SetCondition(dbv1beta1.RedisClusterReconcileError, corev1.ConditionFalse, "ReconcileSucceeded", "")
+	}
+
+	if action != nil {
+		reason := actionName(action)
+		message := ""
+		if requeue, ok := err.(*RequeueError); ok {
+			message = requeue.Reason
+		}
+
+		status.SetCondition(dbv1beta1.RedisClusterProgressing, corev1.ConditionTrue, reason, message)
+		status.SetCondition(dbv1beta1.RedisClusterReady, corev1.ConditionFalse, reason, "cluster has not converged on its spec")
+		return
+	}
+
+	status.SetCondition(dbv1beta1.RedisClusterProgressing, corev1.ConditionFalse, "Converged", "")
+	if err != nil {
+		status.SetCondition(dbv1beta1.RedisClusterReady, corev1.ConditionFalse, "ReconcileFailed", err.Error())
+		return
+	}
+
+	status.SetCondition(dbv1beta1.RedisClusterReady, corev1.ConditionTrue, "Converged", "")
+}
+
+// updateStatus applies a mutation to a RedisCluster and writes its status through the status
+// subresource. On conflict the latest version is fetched and the mutation is reapplied.
+func updateStatus(k8sClient client.Client, redisCluster *dbv1beta1.RedisCluster, mutate func(*dbv1beta1.RedisCluster)) error {
+	fetch := false
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if fetch {
+			if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: redisCluster.Name, Namespace: redisCluster.Namespace}, redisCluster); err != nil {
+				return err
+			}
+		}
+		fetch = true
+
+		mutate(redisCluster)
+
+		return k8sClient.Status().Update(context.TODO(), redisCluster)
+	})
+}
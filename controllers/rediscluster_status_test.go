@@ -0,0 +1,107 @@
+// +build unit
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRedisClusterUpdateConditions(t *testing.T) {
+	conditionStatus := func(redisCluster *dbv1beta1.RedisCluster, conditionType dbv1beta1.RedisClusterConditionType) corev1.ConditionStatus {
+		condition := redisCluster.Status.GetCondition(conditionType)
+		require.NotNil(t, condition)
+		return condition.Status
+	}
+
+	t.Run("converged", func(t *testing.T) {
+		redisCluster := &dbv1beta1.RedisCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Generation: 3,
+			},
+			Status: dbv1beta1.RedisClusterStatus{
+				Nodes: []dbv1beta1.RedisNodeStatus{
+					{
+						IP:     "1.2.3.4",
+						Ready:  true,
+						Joined: true,
+					},
+				},
+			},
+		}
+
+		updateConditions(redisCluster, nil, nil)
+		require.Equal(t, int64(3), redisCluster.Status.ObservedGeneration)
+		require.Equal(t, corev1.ConditionTrue, conditionStatus(redisCluster, dbv1beta1.RedisClusterReady))
+		require.Equal(t, corev1.ConditionFalse, conditionStatus(redisCluster, dbv1beta1.RedisClusterProgressing))
+		require.Equal(t, corev1.ConditionFalse, conditionStatus(redisCluster, dbv1beta1.RedisClusterDegraded))
+		require.Equal(t, corev1.ConditionFalse, conditionStatus(redisCluster, dbv1beta1.RedisClusterReconcileError))
+	})
+
+	t.Run("action in progress", func(t *testing.T) {
+		redisCluster := &dbv1beta1.RedisCluster{
+			Status: dbv1beta1.RedisClusterStatus{
+				Nodes: []dbv1beta1.RedisNodeStatus{
+					{
+						IP: "1.2.3.4",
+						// not ready
+					},
+				},
+			},
+		}
+
+		updateConditions(redisCluster, &AwaitRedisNodes{redisCluster: redisCluster}, requeueAfter(time.Second, "waiting"))
+		require.Equal(t, corev1.ConditionFalse, conditionStatus(redisCluster, dbv1beta1.RedisClusterReady))
+		require.Equal(t, corev1.ConditionTrue, conditionStatus(redisCluster, dbv1beta1.RedisClusterProgressing))
+		require.Equal(t, "AwaitRedisNodes", redisCluster.Status.GetCondition(dbv1beta1.RedisClusterProgressing).Reason)
+		require.Equal(t, corev1.ConditionTrue, conditionStatus(redisCluster, dbv1beta1.RedisClusterDegraded))
+		require.Equal(t, corev1.ConditionFalse, conditionStatus(redisCluster, dbv1beta1.RedisClusterReconcileError))
+	})
+
+	t.Run("action failed", func(t *testing.T) {
+		redisCluster := &dbv1beta1.RedisCluster{}
+
+		updateConditions(redisCluster, &AddRedisNode{}, errors.New("boom"))
+		require.Equal(t, corev1.ConditionFalse, conditionStatus(redisCluster, dbv1beta1.RedisClusterReady))
+		require.Equal(t, corev1.ConditionTrue, conditionStatus(redisCluster, dbv1beta1.RedisClusterReconcileError))
+		require.Equal(t, "boom", redisCluster.Status.GetCondition(dbv1beta1.RedisClusterReconcileError).Message)
+	})
+
+	t.Run("password rotation", func(t *testing.T) {
+		redisCluster := &dbv1beta1.RedisCluster{}
+
+		updateConditions(redisCluster, nil, nil)
+		require.Equal(t, corev1.ConditionFalse, conditionStatus(redisCluster, dbv1beta1.RedisClusterPasswordRotating))
+
+		start := metav1.NewTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+		redisCluster.Spec.Auth = &dbv1beta1.RedisAuth{RotationGracePeriod: &metav1.Duration{Duration: time.Minute}}
+		redisCluster.Status.PasswordRotation = &dbv1beta1.RedisPasswordRotation{
+			AuthHash:             "abc",
+			Phase:                dbv1beta1.RedisPasswordRotationGracePeriod,
+			GracePeriodStartTime: &start,
+		}
+
+		updateConditions(redisCluster, &RotateRedisPassword{}, requeueAfter(time.Minute, "waiting"))
+		condition := redisCluster.Status.GetCondition(dbv1beta1.RedisClusterPasswordRotating)
+		require.Equal(t, corev1.ConditionTrue, condition.Status)
+		require.Equal(t, "GracePeriod", condition.Reason)
+		require.Contains(t, condition.Message, "2020-01-01T00:01:00Z")
+	})
+
+	t.Run("transition time only changes with status", func(t *testing.T) {
+		redisCluster := &dbv1beta1.RedisCluster{}
+
+		updateConditions(redisCluster, nil, nil)
+		transitioned := metav1.NewTime(time.Now().Add(-time.Hour))
+		redisCluster.Status.GetCondition(dbv1beta1.RedisClusterReady).LastTransitionTime = transitioned
+
+		updateConditions(redisCluster, nil, nil)
+		require.Equal(t, transitioned, redisCluster.Status.GetCondition(dbv1beta1.RedisClusterReady).LastTransitionTime)
+	})
+}
@@ -0,0 +1,314 @@
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	redisTLSVolume = "tls"
+	redisTLSPath   = "/etc/redis-tls"
+	// redisCACertificateKey and redisCAKeyKey are the keys of the CA Secret holding the CA's
+	// certificate and private key. The certificate is also copied into every node's Secret.
+	redisCACertificateKey = "ca.crt"
+	redisCAKeyKey         = "ca.key"
+	// redisCAValidity is how long the CA issued for a cluster is valid. It isn't renewed, so
+	// outlives any cluster expected to run on it.
+	redisCAValidity = 10 * 365 * 24 * time.Hour
+	// redisNodeCertificateValidity is how long a node's certificate is used before it's
+	// renewed. Certificates are issued for this long plus the cluster's renewBefore.
+	redisNodeCertificateValidity = 365 * 24 * time.Hour
+)
+
+// redisCASecretName returns the name of the Secret holding the CA a cluster's node
+// certificates are issued by
+func redisCASecretName(redisCluster *dbv1beta1.RedisCluster) string {
+	return fmt.Sprintf("%s-ca", redisCluster.Name)
+}
+
+// redisNodeTLSSecretName returns the name of the Secret holding the certificate a node
+// serves, which is shared by every node when the cluster references one
+func redisNodeTLSSecretName(redisCluster *dbv1beta1.RedisCluster, nodeName string) string {
+	if ref := redisCluster.Spec.TLS.CertificateSecret; ref != nil {
+		return ref.Name
+	}
+
+	return fmt.Sprintf("%s-%s-tls", redisCluster.Name, nodeName)
+}
+
+// certificateRenewBefore returns how long before its certificate expires that a node is
+// restarted with a renewed one
+func certificateRenewBefore(redisCluster *dbv1beta1.RedisCluster) time.Duration {
+	if spec := redisCluster.Spec.TLS; spec != nil && spec.RenewBefore != nil {
+		return spec.RenewBefore.Duration
+	}
+
+	return dbv1beta1.DefaultCertificateRenewBefore
+}
+
+// isCertificateExpiring reports whether a certificate expiring at notAfter is due to be
+// renewed
+func isCertificateExpiring(redisCluster *dbv1beta1.RedisCluster, notAfter time.Time) bool {
+	return time.Until(notAfter) < certificateRenewBefore(redisCluster)
+}
+
+// redisClusterTLSConfig returns the TLS configuration the controller connects to a cluster's
+// nodes with, trusting only the cluster's CA. The CA may not exist yet, in which case no
+// node is trusted.
+func redisClusterTLSConfig(k8sClient client.Client, redisCluster *dbv1beta1.RedisCluster) (*tls.Config, error) {
+	name := redisCASecretName(redisCluster)
+	if ref := redisCluster.Spec.TLS.CertificateSecret; ref != nil {
+		name = ref.Name
+	}
+
+	secret := &corev1.Secret{}
+	if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: redisCluster.Namespace}, secret); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	return newRedisTLSConfig(secret.Data[redisCACertificateKey]), nil
+}
+
+// newRedisTLSConfig returns a TLS configuration trusting the given PEM encoded CA
+// certificates. Nodes are dialled by pod IP, which their certificates don't name, so only
+// the chain of the certificate a node serves is verified.
+func newRedisTLSConfig(caPEM []byte) *tls.Config {
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("node presented no certificate")
+			}
+
+			intermediates := x509.NewCertPool()
+			certificates := make([]*x509.Certificate, len(rawCerts))
+			for i, raw := range rawCerts {
+				certificate, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				certificates[i] = certificate
+				if i > 0 {
+					intermediates.AddCert(certificate)
+				}
+			}
+
+			_, err := certificates[0].Verify(x509.VerifyOptions{
+				Roots:         roots,
+				Intermediates: intermediates,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			})
+			return err
+		},
+	}
+}
+
+// newRedisCASecret returns a Secret holding a newly created, self-signed CA for a cluster
+// that doesn't reference a certificate
+func newRedisCASecret(redisCluster *dbv1beta1.RedisCluster) (*corev1.Secret, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := newCertificateTemplate(fmt.Sprintf("%s redis ca", redisCluster.Name), redisCAValidity)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            redisCASecretName(redisCluster),
+			Namespace:       redisCluster.Namespace,
+			Labels:          redisClusterLabels(redisCluster),
+			OwnerReferences: []metav1.OwnerReference{redisClusterOwnerReference(redisCluster)},
+		},
+		Data: map[string][]byte{
+			redisCACertificateKey: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			redisCAKeyKey:         keyPEM,
+		},
+	}, nil
+}
+
+// issueRedisNodeCertificate returns the contents of a node's TLS Secret: a certificate and
+// key for the node issued by the CA held in caSecret, and the CA's certificate. The node is
+// named by its pod name, and clients connecting through a service named after the cluster
+// are also covered.
+func issueRedisNodeCertificate(redisCluster *dbv1beta1.RedisCluster, caSecret *corev1.Secret, nodeName string) (map[string][]byte, error) {
+	ca, err := tls.X509KeyPair(caSecret.Data[redisCACertificateKey], caSecret.Data[redisCAKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ca secret %s: %s", caSecret.Name, err)
+	}
+	caCertificate, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	podName := redisNodePodName(redisCluster, nodeName)
+	template, err := newCertificateTemplate(podName, redisNodeCertificateValidity+certificateRenewBefore(redisCluster))
+	if err != nil {
+		return nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	// nodes present their certificate to each other on the cluster bus and replication links
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	template.DNSNames = []string{
+		podName,
+		redisCluster.Name,
+		fmt.Sprintf("%s.%s.svc", redisCluster.Name, redisCluster.Namespace),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCertificate, key.Public(), ca.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string][]byte{
+		corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		corev1.TLSPrivateKeyKey: keyPEM,
+		redisCACertificateKey:   caSecret.Data[redisCACertificateKey],
+	}, nil
+}
+
+func newCertificateTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	// backdated to tolerate clock skew between the controller and the nodes
+	notBefore := time.Now().Add(-time.Hour)
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(validity),
+	}, nil
+}
+
+func encodePrivateKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// certificateNotAfter returns when the first certificate of a PEM encoded chain expires
+func certificateNotAfter(certPEM []byte) (time.Time, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return time.Time{}, fmt.Errorf("no pem encoded certificate found")
+	}
+
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return certificate.NotAfter, nil
+}
+
+// ensureRedisCASecret returns the Secret holding the CA of a cluster, creating it if it
+// doesn't exist
+func ensureRedisCASecret(k8sClient client.Client, redisCluster *dbv1beta1.RedisCluster) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: redisCASecretName(redisCluster), Namespace: redisCluster.Namespace}, secret)
+	if !k8serrors.IsNotFound(err) {
+		return secret, err
+	}
+
+	if secret, err = newRedisCASecret(redisCluster); err != nil {
+		return nil, err
+	}
+
+	return secret, k8sClient.Create(context.TODO(), secret)
+}
+
+// applyRedisNodeCertificate issues a node the certificate it serves, creating the node's TLS
+// Secret if it doesn't exist or renewing the certificate if it's due to be renewed. A node
+// only serves a renewed certificate once it's restarted.
+func applyRedisNodeCertificate(k8sClient client.Client, redisCluster *dbv1beta1.RedisCluster, nodeName string) error {
+	existing := &corev1.Secret{}
+	err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: redisNodeTLSSecretName(redisCluster, nodeName), Namespace: redisCluster.Namespace}, existing)
+	switch {
+	case k8serrors.IsNotFound(err):
+		existing = nil
+	case err != nil:
+		return err
+	default:
+		notAfter, err := certificateNotAfter(existing.Data[corev1.TLSCertKey])
+		if err == nil && !isCertificateExpiring(redisCluster, notAfter) {
+			return nil
+		}
+	}
+
+	ca, err := ensureRedisCASecret(k8sClient, redisCluster)
+	if err != nil {
+		return err
+	}
+
+	data, err := issueRedisNodeCertificate(redisCluster, ca, nodeName)
+	if err != nil {
+		return err
+	}
+
+	if existing != nil {
+		existing.Data = data
+		return k8sClient.Update(context.TODO(), existing)
+	}
+
+	return k8sClient.Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            redisNodeTLSSecretName(redisCluster, nodeName),
+			Namespace:       redisCluster.Namespace,
+			Labels:          redisNodeLabels(redisCluster, nodeName),
+			OwnerReferences: []metav1.OwnerReference{redisClusterOwnerReference(redisCluster)},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: data,
+	})
+}
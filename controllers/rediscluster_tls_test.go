@@ -0,0 +1,64 @@
+// +build unit
+
+package controllers
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIssueRedisNodeCertificate(t *testing.T) {
+	redisCluster := &dbv1beta1.RedisCluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	redisCluster.Spec.TLS = &dbv1beta1.RedisTLS{RenewBefore: &metav1.Duration{Duration: time.Hour}}
+
+	ca, err := newRedisCASecret(redisCluster)
+	require.NoError(t, err)
+	require.Equal(t, "test-ca", ca.Name)
+
+	data, err := issueRedisNodeCertificate(redisCluster, ca, "a")
+	require.NoError(t, err)
+	require.Equal(t, ca.Data[redisCACertificateKey], data[redisCACertificateKey])
+
+	certificate, err := tls.X509KeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey])
+	require.NoError(t, err)
+
+	notAfter, err := certificateNotAfter(data[corev1.TLSCertKey])
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(redisNodeCertificateValidity), notAfter, 2*time.Hour)
+	require.False(t, isCertificateExpiring(redisCluster, notAfter))
+
+	// nodes are only trusted if their certificate was issued by the cluster's CA
+	verify := newRedisTLSConfig(ca.Data[redisCACertificateKey]).VerifyPeerCertificate
+	require.NoError(t, verify(certificate.Certificate, nil))
+
+	other, err := newRedisCASecret(redisCluster)
+	require.NoError(t, err)
+	require.Error(t, newRedisTLSConfig(other.Data[redisCACertificateKey]).VerifyPeerCertificate(certificate.Certificate, nil))
+	require.Error(t, newRedisTLSConfig(nil).VerifyPeerCertificate(certificate.Certificate, nil))
+}
+
+func TestCertificateNotAfter(t *testing.T) {
+	_, err := certificateNotAfter(nil)
+	require.Error(t, err)
+
+	_, err = certificateNotAfter(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte("key")}))
+	require.Error(t, err)
+}
+
+func TestCertificateRenewBefore(t *testing.T) {
+	redisCluster := &dbv1beta1.RedisCluster{}
+	redisCluster.Spec.TLS = &dbv1beta1.RedisTLS{}
+	require.Equal(t, dbv1beta1.DefaultCertificateRenewBefore, certificateRenewBefore(redisCluster))
+	require.True(t, isCertificateExpiring(redisCluster, time.Now().Add(24*time.Hour)))
+
+	redisCluster.Spec.TLS.RenewBefore = &metav1.Duration{Duration: time.Hour}
+	require.Equal(t, time.Hour, certificateRenewBefore(redisCluster))
+	require.False(t, isCertificateExpiring(redisCluster, time.Now().Add(24*time.Hour)))
+}
@@ -0,0 +1,207 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+)
+
+// redisFailoverTimeout is how long a replica has to be promoted once asked to fail over its
+// master. Redis aborts a manual failover that the master doesn't agree to within seconds.
+const redisFailoverTimeout = time.Minute
+
+// RedisFailoverReconciler reconciles a RedisFailover object
+type RedisFailoverReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=db.k8s.io,resources=redisfailovers,verbs=get;list;watch
+// +kubebuilder:rbac:groups=db.k8s.io,resources=redisfailovers/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+func (r *RedisFailoverReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("redisfailover", req.NamespacedName)
+
+	failover := &dbv1beta1.RedisFailover{}
+	if err := r.Get(context.TODO(), req.NamespacedName, failover); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return ctrl.Result{}, nil // deleted
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	// a failover is only ever attempted once, a new RedisFailover is needed to try again
+	if failover.Status.Phase == dbv1beta1.RedisFailoverSucceeded || failover.Status.Phase == dbv1beta1.RedisFailoverFailed {
+		return ctrl.Result{}, nil
+	}
+
+	redisCluster := &dbv1beta1.RedisCluster{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Name: failover.Spec.ClusterName, Namespace: failover.Namespace}, redisCluster); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return ctrl.Result{}, r.complete(failover, dbv1beta1.RedisFailoverFailed, "ClusterNotFound", fmt.Sprintf("redis cluster %s does not exist", failover.Spec.ClusterName))
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	node, ok := statusNodesByName(redisCluster)[failover.Spec.NodeName]
+
+	auth, err := redisClusterAuth(r.Client, redisCluster)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if failover.Status.Phase == "" {
+		if reason, message := checkFailoverNode(failover, node, ok); reason != "" {
+			return ctrl.Result{}, r.complete(failover, dbv1beta1.RedisFailoverFailed, reason, message)
+		}
+
+		client, err := dialRedisNode(auth, node.IP)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		defer client.Close()
+
+		if err := client.ClusterFailover(redisFailoverOption(failover.Spec.Option)); err != nil {
+			return ctrl.Result{}, r.complete(failover, dbv1beta1.RedisFailoverFailed, "FailoverRejected", err.Error())
+		}
+		log.Info("requested redis node failover", "node", node.Name, "master", node.MasterName, "option", failover.Spec.Option)
+
+		now := metav1.Now()
+		err = r.updateStatus(failover, func(failover *dbv1beta1.RedisFailover) {
+			failover.Status.Phase = dbv1beta1.RedisFailoverRunning
+			failover.Status.PreviousMasterName = node.MasterName
+			failover.Status.StartTime = &now
+			failover.Status.Reason = "FailoverStarted"
+			failover.Status.Message = fmt.Sprintf("promoting %s, a replica of %s", node.Name, node.MasterName)
+		})
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Event(failover, corev1.EventTypeNormal, failover.Status.Reason, failover.Status.Message)
+
+		return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
+	}
+
+	// the node may have been removed from the cluster, e.g. by scaling it down, since the
+	// failover started
+	if !ok {
+		return ctrl.Result{}, r.complete(failover, dbv1beta1.RedisFailoverFailed, "NodeNotFound", fmt.Sprintf("node %s was removed from redis cluster %s during the failover", failover.Spec.NodeName, failover.Spec.ClusterName))
+	}
+
+	clusterNode, _, err := observeRedisNode(auth, node.IP)
+	if err != nil {
+		log.Info("unable to observe redis node", "node", node.Name, "error", err.Error())
+	} else if clusterNode.HasFlag("master") {
+		return ctrl.Result{}, r.complete(failover, dbv1beta1.RedisFailoverSucceeded, "FailoverSucceeded", fmt.Sprintf("%s has taken over from %s as master", node.Name, failover.Status.PreviousMasterName))
+	}
+
+	if failover.Status.StartTime != nil && time.Since(failover.Status.StartTime.Time) > redisFailoverTimeout {
+		return ctrl.Result{}, r.complete(failover, dbv1beta1.RedisFailoverFailed, "FailoverTimedOut", fmt.Sprintf("%s was not promoted within %s", node.Name, redisFailoverTimeout))
+	}
+
+	return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
+}
+
+// complete records the outcome of a failover in its status and as an event
+func (r *RedisFailoverReconciler) complete(failover *dbv1beta1.RedisFailover, phase dbv1beta1.RedisFailoverPhase, reason, message string) error {
+	now := metav1.Now()
+	err := r.updateStatus(failover, func(failover *dbv1beta1.RedisFailover) {
+		failover.Status.Phase = phase
+		failover.Status.CompletionTime = &now
+		failover.Status.Reason = reason
+		failover.Status.Message = message
+	})
+	if err != nil {
+		return err
+	}
+
+	eventType := corev1.EventTypeNormal
+	if phase == dbv1beta1.RedisFailoverFailed {
+		eventType = corev1.EventTypeWarning
+	}
+	r.Recorder.Event(failover, eventType, reason, message)
+	r.Log.Info("redis failover completed", "redisfailover", failover.Name, "phase", phase, "reason", reason)
+
+	return nil
+}
+
+// updateStatus applies a mutation to a RedisFailover and writes its status through the status
+// subresource. On conflict the latest version is fetched and the mutation is reapplied.
+func (r *RedisFailoverReconciler) updateStatus(failover *dbv1beta1.RedisFailover, mutate func(*dbv1beta1.RedisFailover)) error {
+	fetch := false
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if fetch {
+			if err := r.Get(context.TODO(), types.NamespacedName{Name: failover.Name, Namespace: failover.Namespace}, failover); err != nil {
+				return err
+			}
+		}
+		fetch = true
+
+		mutate(failover)
+
+		return r.Status().Update(context.TODO(), failover)
+	})
+}
+
+func (r *RedisFailoverReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dbv1beta1.RedisFailover{}).
+		Complete(r)
+}
+
+// checkFailoverNode returns the reason and message for which the node named by a failover
+// can't be promoted, or "" if it can
+func checkFailoverNode(failover *dbv1beta1.RedisFailover, node dbv1beta1.RedisNodeStatus, ok bool) (string, string) {
+	switch {
+	case !ok:
+		return "NodeNotFound", fmt.Sprintf("redis cluster %s has no node %s", failover.Spec.ClusterName, failover.Spec.NodeName)
+	case !node.Ready || node.NodeID == "":
+		return "NodeNotReady", fmt.Sprintf("node %s is not ready", node.Name)
+	case node.Role != dbv1beta1.RedisNodeRoleReplica:
+		return "NotAReplica", fmt.Sprintf("node %s is not a replica", node.Name)
+	}
+
+	return "", ""
+}
+
+// redisFailoverOption returns the CLUSTER FAILOVER option corresponding to a FailoverOption
+func redisFailoverOption(option dbv1beta1.FailoverOption) string {
+	switch option {
+	case dbv1beta1.FailoverOptionForce:
+		return "FORCE"
+	case dbv1beta1.FailoverOptionTakeover:
+		return "TAKEOVER"
+	}
+
+	return ""
+}
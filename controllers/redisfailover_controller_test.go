@@ -0,0 +1,128 @@
+// +build unit
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+	"github.com/stretchr/testify/require"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// conflictingFakeClient fails the first status update with a conflict, as the api server does
+// when the object was modified since it was read
+type conflictingFakeClient struct {
+	client.Client
+	conflicts int
+}
+
+func (c *conflictingFakeClient) Status() client.StatusWriter {
+	return &conflictingStatusWriter{StatusWriter: c.Client.Status(), client: c}
+}
+
+type conflictingStatusWriter struct {
+	client.StatusWriter
+	client *conflictingFakeClient
+}
+
+func (w *conflictingStatusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOptionFunc) error {
+	if w.client.conflicts == 0 {
+		w.client.conflicts++
+		return k8serrors.NewConflict(schema.GroupResource{Group: "db.k8s.io", Resource: "redisfailovers"}, "test", nil)
+	}
+
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func TestCheckFailoverNode(t *testing.T) {
+	failover := &dbv1beta1.RedisFailover{
+		Spec: dbv1beta1.RedisFailoverSpec{
+			ClusterName: "test",
+			NodeName:    "b",
+		},
+	}
+
+	t.Run("replica", func(t *testing.T) {
+		node := dbv1beta1.RedisNodeStatus{Name: "b", NodeID: "2", Ready: true, Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"}
+		reason, _ := checkFailoverNode(failover, node, true)
+		require.Empty(t, reason)
+	})
+
+	t.Run("node not found", func(t *testing.T) {
+		reason, message := checkFailoverNode(failover, dbv1beta1.RedisNodeStatus{}, false)
+		require.Equal(t, "NodeNotFound", reason)
+		require.Contains(t, message, "test")
+	})
+
+	t.Run("node not ready", func(t *testing.T) {
+		node := dbv1beta1.RedisNodeStatus{Name: "b", NodeID: "2", Role: dbv1beta1.RedisNodeRoleReplica, MasterName: "a"}
+		reason, _ := checkFailoverNode(failover, node, true)
+		require.Equal(t, "NodeNotReady", reason)
+	})
+
+	t.Run("master", func(t *testing.T) {
+		node := dbv1beta1.RedisNodeStatus{Name: "b", NodeID: "2", Ready: true, Role: dbv1beta1.RedisNodeRoleMaster, Slots: 8192}
+		reason, _ := checkFailoverNode(failover, node, true)
+		require.Equal(t, "NotAReplica", reason)
+	})
+}
+
+func TestRedisFailoverOption(t *testing.T) {
+	require.Equal(t, "", redisFailoverOption(""))
+	require.Equal(t, "FORCE", redisFailoverOption(dbv1beta1.FailoverOptionForce))
+	require.Equal(t, "TAKEOVER", redisFailoverOption(dbv1beta1.FailoverOptionTakeover))
+}
+
+func TestRedisFailoverReconcile(t *testing.T) {
+	newFailover := func() *dbv1beta1.RedisFailover {
+		return &dbv1beta1.RedisFailover{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			Spec:       dbv1beta1.RedisFailoverSpec{ClusterName: "test", NodeName: "b"},
+		}
+	}
+
+	reconcile := func(t *testing.T, objs ...runtime.Object) (*dbv1beta1.RedisFailover, *conflictingFakeClient) {
+		k8sClient := &conflictingFakeClient{Client: newFakeK8sClient(t, objs...)}
+		reconciler := &RedisFailoverReconciler{Client: k8sClient, Log: zap.Logger(true), Recorder: record.NewFakeRecorder(10)}
+
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: "test", Namespace: "default"}})
+		require.NoError(t, err)
+
+		failover := &dbv1beta1.RedisFailover{}
+		require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test", Namespace: "default"}, failover))
+		return failover, k8sClient
+	}
+
+	t.Run("cluster not found", func(t *testing.T) {
+		failover, k8sClient := reconcile(t, newFailover())
+		require.Equal(t, dbv1beta1.RedisFailoverFailed, failover.Status.Phase)
+		require.Equal(t, "ClusterNotFound", failover.Status.Reason)
+		require.Equal(t, 1, k8sClient.conflicts)
+	})
+
+	t.Run("node removed while running", func(t *testing.T) {
+		redisCluster := newTestActionRedisCluster(
+			dbv1beta1.RedisNodeStatus{Name: "a", IP: "10.0.0.1", Role: dbv1beta1.RedisNodeRoleMaster},
+		)
+		failover := newFailover()
+		now := metav1.NewTime(time.Now())
+		failover.Status.Phase = dbv1beta1.RedisFailoverRunning
+		failover.Status.StartTime = &now
+
+		failover, _ = reconcile(t, failover, redisCluster)
+		require.Equal(t, dbv1beta1.RedisFailoverFailed, failover.Status.Phase)
+		require.Equal(t, "NodeNotFound", failover.Status.Reason)
+		require.NotNil(t, failover.Status.CompletionTime)
+	})
+}
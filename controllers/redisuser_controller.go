@@ -0,0 +1,300 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+)
+
+const (
+	// redisUserFinalizer keeps a RedisUser until its ACL user has been deleted from every node
+	redisUserFinalizer = "db.k8s.io/delete-acl-user"
+	// redisUserResyncPeriod is how often users are reapplied to catch nodes they couldn't be
+	// applied to. Nodes that restart, and so lose their ACL users, are reapplied to as soon
+	// as their new start time is observed.
+	redisUserResyncPeriod = 30 * time.Second
+)
+
+// RedisUserReconciler reconciles a RedisUser object
+type RedisUserReconciler struct {
+	client.Client
+	Log logr.Logger
+}
+
+// +kubebuilder:rbac:groups=db.k8s.io,resources=redisusers,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=db.k8s.io,resources=redisusers/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+func (r *RedisUserReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("redisuser", req.NamespacedName)
+
+	user := &dbv1beta1.RedisUser{}
+	if err := r.Get(context.TODO(), req.NamespacedName, user); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return ctrl.Result{}, nil // deleted
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	if user.DeletionTimestamp != nil {
+		if !containsString(user.Finalizers, redisUserFinalizer) {
+			return ctrl.Result{}, nil
+		}
+
+		if err := r.deleteUser(user); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info("deleted redis acl user", "username", redisUsername(user))
+
+		user.Finalizers = removeString(user.Finalizers, redisUserFinalizer)
+		return ctrl.Result{}, r.Update(context.TODO(), user)
+	}
+
+	if !containsString(user.Finalizers, redisUserFinalizer) {
+		user.Finalizers = append(user.Finalizers, redisUserFinalizer)
+		if err := r.Update(context.TODO(), user); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if reason, message := checkRedisUser(user); reason != "" {
+		return ctrl.Result{}, r.updateStatus(user, dbv1beta1.RedisUserFailed, 0, reason, message)
+	}
+
+	redisCluster := &dbv1beta1.RedisCluster{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Name: user.Spec.ClusterName, Namespace: user.Namespace}, redisCluster); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+
+		message := fmt.Sprintf("redis cluster %s does not exist", user.Spec.ClusterName)
+		return ctrl.Result{RequeueAfter: redisUserResyncPeriod}, r.updateStatus(user, dbv1beta1.RedisUserPending, 0, "ClusterNotFound", message)
+	}
+
+	password, err := redisUserPassword(r.Client, user)
+	if err != nil {
+		return ctrl.Result{RequeueAfter: redisUserResyncPeriod}, r.updateStatus(user, dbv1beta1.RedisUserPending, 0, "PasswordNotFound", err.Error())
+	}
+
+	synced, err := r.applyUser(user, redisCluster, password)
+	switch {
+	case err != nil:
+		log.Info("unable to apply redis acl user to every node", "username", redisUsername(user), "error", err.Error())
+		err = r.updateStatus(user, dbv1beta1.RedisUserPending, synced, "NodesPending", err.Error())
+	case int(synced) < len(redisCluster.Status.Nodes) || synced == 0:
+		message := fmt.Sprintf("applied to %d of %d nodes, waiting for the rest to become ready", synced, len(redisCluster.Status.Nodes))
+		err = r.updateStatus(user, dbv1beta1.RedisUserPending, synced, "NodesPending", message)
+	default:
+		message := fmt.Sprintf("applied to all %d nodes", synced)
+		err = r.updateStatus(user, dbv1beta1.RedisUserSynced, synced, "UserSynced", message)
+	}
+
+	return ctrl.Result{RequeueAfter: redisUserResyncPeriod}, err
+}
+
+// applyUser creates or replaces the ACL user on every ready node of a cluster, returning the
+// number of nodes it was applied to. The user is reset first so that rules removed from the
+// spec are also removed from the nodes.
+func (r *RedisUserReconciler) applyUser(user *dbv1beta1.RedisUser, redisCluster *dbv1beta1.RedisCluster, password string) (int32, error) {
+	auth, err := redisClusterAuth(r.Client, redisCluster)
+	if err != nil {
+		return 0, err
+	}
+
+	rules := append([]string{"reset", "on", ">" + password}, user.Spec.Rules...)
+
+	synced := int32(0)
+	for _, node := range redisCluster.Status.Nodes {
+		if !node.Ready || node.IP == "" {
+			continue
+		}
+
+		if err := setRedisUser(auth, node.IP, redisUsername(user), rules); err != nil {
+			return synced, fmt.Errorf("unable to apply user to node %s: %s", node.Name, err)
+		}
+		synced++
+	}
+
+	return synced, nil
+}
+
+// deleteUser deletes the ACL user from every ready node of its cluster. Nodes that aren't
+// ready will have lost the user when they restarted.
+func (r *RedisUserReconciler) deleteUser(user *dbv1beta1.RedisUser) error {
+	redisCluster := &dbv1beta1.RedisCluster{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Name: user.Spec.ClusterName, Namespace: user.Namespace}, redisCluster); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil // the user went with the cluster
+		}
+
+		return err
+	}
+
+	if redisCluster.DeletionTimestamp != nil {
+		return nil
+	}
+
+	auth, err := redisClusterAuth(r.Client, redisCluster)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range redisCluster.Status.Nodes {
+		if !node.Ready || node.IP == "" {
+			continue
+		}
+
+		if err := deleteRedisUser(auth, node.IP, redisUsername(user)); err != nil {
+			return fmt.Errorf("unable to delete user from node %s: %s", node.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// updateStatus records the phase of a user, unless it's unchanged so that the update
+// doesn't trigger another reconcile
+func (r *RedisUserReconciler) updateStatus(user *dbv1beta1.RedisUser, phase dbv1beta1.RedisUserPhase, synced int32, reason, message string) error {
+	status := dbv1beta1.RedisUserStatus{
+		Phase:              phase,
+		ObservedGeneration: user.Generation,
+		SyncedNodes:        synced,
+		Reason:             reason,
+		Message:            message,
+	}
+	if user.Status == status {
+		return nil
+	}
+
+	user.Status = status
+	return r.Status().Update(context.TODO(), user)
+}
+
+func (r *RedisUserReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dbv1beta1.RedisUser{}).
+		Watches(&source.Kind{Type: &dbv1beta1.RedisCluster{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.redisClusterRequests),
+		}).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.passwordSecretRequests),
+		}).
+		Complete(r)
+}
+
+// redisClusterRequests maps a RedisCluster to its users, so that they're applied to nodes as
+// soon as they become ready, and reapplied as soon as the status records that a node has
+// restarted
+func (r *RedisUserReconciler) redisClusterRequests(obj handler.MapObject) []reconcile.Request {
+	return r.userRequests(obj.Meta.GetNamespace(), func(user *dbv1beta1.RedisUser) bool {
+		return user.Spec.ClusterName == obj.Meta.GetName()
+	})
+}
+
+// passwordSecretRequests maps a Secret to the users whose password it holds
+func (r *RedisUserReconciler) passwordSecretRequests(obj handler.MapObject) []reconcile.Request {
+	return r.userRequests(obj.Meta.GetNamespace(), func(user *dbv1beta1.RedisUser) bool {
+		return user.Spec.PasswordSecret.Name == obj.Meta.GetName()
+	})
+}
+
+func (r *RedisUserReconciler) userRequests(namespace string, match func(*dbv1beta1.RedisUser) bool) []reconcile.Request {
+	users := &dbv1beta1.RedisUserList{}
+	if err := r.List(context.TODO(), users, client.InNamespace(namespace)); err != nil {
+		r.Log.Error(err, "unable to list redis users", "namespace", namespace)
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for i := range users.Items {
+		if match(&users.Items[i]) {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: users.Items[i].Name, Namespace: namespace}})
+		}
+	}
+
+	return requests
+}
+
+// redisUsername returns the name of a RedisUser's ACL user
+func redisUsername(user *dbv1beta1.RedisUser) string {
+	if user.Spec.Username != "" {
+		return user.Spec.Username
+	}
+
+	return user.Name
+}
+
+// redisUserPassword returns the password of a RedisUser from the Secret it references
+func redisUserPassword(k8sClient client.Client, user *dbv1beta1.RedisUser) (string, error) {
+	ref := user.Spec.PasswordSecret
+	secret := &corev1.Secret{}
+	if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: ref.Name, Namespace: user.Namespace}, secret); err != nil {
+		return "", err
+	}
+
+	password := string(secret.Data[ref.Key])
+	if password == "" {
+		return "", fmt.Errorf("password secret %s has no %s key", ref.Name, ref.Key)
+	}
+
+	return password, nil
+}
+
+// redisPasswordRules are the ACL rules that set or remove passwords, which are managed through
+// the password Secret instead
+var redisPasswordRules = map[string]bool{
+	"nopass":    true,
+	"resetpass": true,
+	"reset":     true,
+}
+
+// checkRedisUser returns the reason and message for which a user can't be applied, or "" if
+// it can
+func checkRedisUser(user *dbv1beta1.RedisUser) (string, string) {
+	username := redisUsername(user)
+	switch {
+	case username == "default":
+		return "ReservedUsername", "the default user's password is set through the redis cluster's spec.auth"
+	case strings.ContainsAny(username, " \t\r\n"):
+		return "InvalidUsername", fmt.Sprintf("username %q must not contain whitespace", username)
+	}
+
+	for _, rule := range user.Spec.Rules {
+		switch {
+		case rule == "" || strings.ContainsAny(rule, " \t\r\n"):
+			return "InvalidRule", fmt.Sprintf("rule %q must be a single, non-empty acl rule", rule)
+		case strings.ContainsAny(rule[:1], "><#!") || redisPasswordRules[strings.ToLower(rule)]:
+			return "InvalidRule", fmt.Sprintf("rule %q must not set or remove passwords, they're set through passwordSecret", rule)
+		}
+	}
+
+	return "", ""
+}
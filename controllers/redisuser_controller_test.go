@@ -0,0 +1,56 @@
+// +build unit
+
+package controllers
+
+import (
+	"testing"
+
+	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckRedisUser(t *testing.T) {
+	newUser := func(username string, rules ...string) *dbv1beta1.RedisUser {
+		return &dbv1beta1.RedisUser{
+			ObjectMeta: metav1.ObjectMeta{Name: "app"},
+			Spec: dbv1beta1.RedisUserSpec{
+				ClusterName: "test",
+				Username:    username,
+				Rules:       rules,
+			},
+		}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		user := newUser("", "~cache:*", "+@read", "-flushall", "off")
+		reason, _ := checkRedisUser(user)
+		require.Empty(t, reason)
+		require.Equal(t, "app", redisUsername(user))
+	})
+
+	t.Run("default user", func(t *testing.T) {
+		reason, _ := checkRedisUser(newUser("default"))
+		require.Equal(t, "ReservedUsername", reason)
+	})
+
+	t.Run("username with whitespace", func(t *testing.T) {
+		reason, _ := checkRedisUser(newUser("app user"))
+		require.Equal(t, "InvalidUsername", reason)
+	})
+
+	t.Run("password rules", func(t *testing.T) {
+		for _, rule := range []string{">secret", "<secret", "#5e884898", "!5e884898", "nopass", "ResetPass", "reset"} {
+			reason, message := checkRedisUser(newUser("", "+@read", rule))
+			require.Equal(t, "InvalidRule", reason, rule)
+			require.Contains(t, message, rule)
+		}
+	})
+
+	t.Run("malformed rules", func(t *testing.T) {
+		for _, rule := range []string{"", "+get +set"} {
+			reason, _ := checkRedisUser(newUser("", rule))
+			require.Equal(t, "InvalidRule", reason, rule)
+		}
+	})
+}
@@ -19,9 +19,11 @@ import (
 	"flag"
 	"os"
 
+	dbv1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1"
 	dbv1beta1 "github.com/eggsbenjamin/k8s_controller_experiment/api/v1beta1"
 	"github.com/eggsbenjamin/k8s_controller_experiment/controllers"
 	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -35,8 +37,9 @@ var (
 
 func init() {
 
+	clientgoscheme.AddToScheme(scheme)
 	dbv1beta1.AddToScheme(scheme)
-	dbv1beta1.AddToScheme(scheme)
+	dbv1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -61,12 +64,26 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "RedisCluster")
 		os.Exit(1)
 	}
-	err = (&controllers.RedisClusterReconciler{
+	err = (&controllers.RedisFailoverReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("RedisFailover"),
+		Recorder: mgr.GetEventRecorderFor("redisfailover-controller"),
+	}).SetupWithManager(mgr)
+	if err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "RedisFailover")
+		os.Exit(1)
+	}
+	err = (&controllers.RedisUserReconciler{
 		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("RedisCluster"),
+		Log:    ctrl.Log.WithName("controllers").WithName("RedisUser"),
 	}).SetupWithManager(mgr)
 	if err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "RedisCluster")
+		setupLog.Error(err, "unable to create controller", "controller", "RedisUser")
+		os.Exit(1)
+	}
+	// v1beta1 webhooks are registered by the RedisCluster controller, as its For type
+	if err = dbv1beta1.SetupHubWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "RedisCluster")
 		os.Exit(1)
 	}
 	// +kubebuilder:scaffold:builder
@@ -0,0 +1,258 @@
+// Package redis implements a minimal Redis client covering the commands needed to
+// administer a Redis Cluster.
+package redis
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Error is an error reply returned by a Redis server
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Client is a connection to a single Redis node speaking the RESP protocol
+type Client struct {
+	addr     string
+	conn     net.Conn
+	reader   *bufio.Reader
+	timeout  time.Duration
+	password string
+}
+
+// Dial connects to the Redis node at addr. The timeout is applied to the connection
+// attempt and to every subsequent command.
+func Dial(addr string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		addr:    addr,
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: timeout,
+	}, nil
+}
+
+// DialTLS connects to the Redis node at addr over TLS. The timeout is applied to the
+// connection attempt, including the handshake, and to every subsequent command.
+func DialTLS(addr string, timeout time.Duration, config *tls.Config) (*Client, error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		addr:    addr,
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: timeout,
+	}, nil
+}
+
+// PeerCertificate returns the certificate presented by the node, or nil if the connection
+// doesn't use TLS
+func (c *Client) PeerCertificate() *x509.Certificate {
+	conn, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	if certificates := conn.ConnectionState().PeerCertificates; len(certificates) > 0 {
+		return certificates[0]
+	}
+
+	return nil
+}
+
+// Addr returns the address of the node the client is connected to
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+// Password returns the password the client authenticated with, or "" if it hasn't
+func (c *Client) Password() string {
+	return c.password
+}
+
+// Close closes the underlying connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Do sends a command and returns its reply. Replies are decoded as string (simple and
+// bulk strings), int64 (integers), nil (null bulk strings and arrays) or []interface{}
+// (arrays). Error replies are returned as an Error.
+func (c *Client) Do(args ...string) (interface{}, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	reply, err := readReply(c.reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if replyErr, ok := reply.(Error); ok {
+		return nil, replyErr
+	}
+
+	return reply, nil
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		replies := make([]interface{}, n)
+		for i := range replies {
+			reply, err := readReply(r)
+			if err != nil {
+				return nil, err
+			}
+
+			replies[i] = reply
+		}
+
+		return replies, nil
+	}
+
+	return nil, fmt.Errorf("redis: unexpected reply type %q", line[0])
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: malformed reply line %q", line)
+	}
+
+	return line[:len(line)-2], nil
+}
+
+// String converts a reply to a string
+func String(reply interface{}, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+
+	s, ok := reply.(string)
+	if !ok {
+		return "", fmt.Errorf("redis: unexpected reply %#v, expected string", reply)
+	}
+
+	return s, nil
+}
+
+// Int converts an integer reply to an int
+func Int(reply interface{}, err error) (int, error) {
+	if err != nil {
+		return 0, err
+	}
+
+	i, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected reply %#v, expected integer", reply)
+	}
+
+	return int(i), nil
+}
+
+// Strings converts an array reply to a slice of strings
+func Strings(reply interface{}, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	if reply == nil {
+		return nil, nil
+	}
+
+	replies, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected reply %#v, expected array", reply)
+	}
+
+	strs := make([]string, len(replies))
+	for i, r := range replies {
+		if strs[i], err = String(r, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return strs, nil
+}
+
+// OK checks that a command succeeded, discarding its reply
+func OK(reply interface{}, err error) error {
+	return err
+}
@@ -0,0 +1,291 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClusterSlots is the number of hash slots in a Redis Cluster
+const ClusterSlots = 16384
+
+// SlotRange is an inclusive range of hash slots
+type SlotRange struct {
+	Start int
+	End   int
+}
+
+// Len returns the number of slots in the range
+func (r SlotRange) Len() int {
+	return r.End - r.Start + 1
+}
+
+// ClusterNode is a node as described by a line of CLUSTER NODES output
+type ClusterNode struct {
+	ID        string
+	IP        string
+	Port      int
+	Flags     []string
+	MasterID  string
+	LinkState string
+	Slots     []SlotRange
+	// Migrating maps slots being migrated away from this node to the destination node id
+	Migrating map[int]string
+	// Importing maps slots being imported into this node to the source node id
+	Importing map[int]string
+}
+
+// HasFlag reports whether the node has the given flag, e.g. "myself", "master", "fail"
+func (n ClusterNode) HasFlag(flag string) bool {
+	for _, f := range n.Flags {
+		if f == flag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SlotCount returns the number of slots served by the node
+func (n ClusterNode) SlotCount() int {
+	count := 0
+	for _, r := range n.Slots {
+		count += r.Len()
+	}
+
+	return count
+}
+
+// SlotList returns every slot served by the node in ascending order
+func (n ClusterNode) SlotList() []int {
+	slots := make([]int, 0, n.SlotCount())
+	for _, r := range n.Slots {
+		for slot := r.Start; slot <= r.End; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+
+	return slots
+}
+
+// ParseClusterNodes parses the output of CLUSTER NODES
+func ParseClusterNodes(s string) ([]ClusterNode, error) {
+	nodes := []ClusterNode{}
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 8 {
+			return nil, fmt.Errorf("redis: malformed cluster nodes line %q", line)
+		}
+
+		node := ClusterNode{
+			ID:        fields[0],
+			Flags:     strings.Split(fields[2], ","),
+			LinkState: fields[7],
+			Migrating: map[int]string{},
+			Importing: map[int]string{},
+		}
+
+		if fields[3] != "-" {
+			node.MasterID = fields[3]
+		}
+
+		// address is ip:port@cport, optionally followed by ,hostname
+		addr := strings.SplitN(strings.SplitN(fields[1], ",", 2)[0], "@", 2)[0]
+		if i := strings.LastIndex(addr, ":"); i >= 0 {
+			node.IP = addr[:i]
+			port, err := strconv.Atoi(addr[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("redis: malformed node address %q", fields[1])
+			}
+
+			node.Port = port
+		}
+
+		for _, field := range fields[8:] {
+			if err := parseSlotField(&node, field); err != nil {
+				return nil, err
+			}
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, scanner.Err()
+}
+
+func parseSlotField(node *ClusterNode, field string) error {
+	// open slots are reported as [slot->-id] when migrating and [slot-<-id] when importing
+	if strings.HasPrefix(field, "[") {
+		field = strings.Trim(field, "[]")
+		if i := strings.Index(field, "->-"); i >= 0 {
+			slot, err := strconv.Atoi(field[:i])
+			if err != nil {
+				return fmt.Errorf("redis: malformed migrating slot %q", field)
+			}
+
+			node.Migrating[slot] = field[i+3:]
+			return nil
+		}
+
+		if i := strings.Index(field, "-<-"); i >= 0 {
+			slot, err := strconv.Atoi(field[:i])
+			if err != nil {
+				return fmt.Errorf("redis: malformed importing slot %q", field)
+			}
+
+			node.Importing[slot] = field[i+3:]
+			return nil
+		}
+
+		return fmt.Errorf("redis: malformed open slot %q", field)
+	}
+
+	bounds := strings.SplitN(field, "-", 2)
+	start, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return fmt.Errorf("redis: malformed slot range %q", field)
+	}
+
+	end := start
+	if len(bounds) == 2 {
+		if end, err = strconv.Atoi(bounds[1]); err != nil {
+			return fmt.Errorf("redis: malformed slot range %q", field)
+		}
+	}
+
+	node.Slots = append(node.Slots, SlotRange{Start: start, End: end})
+	return nil
+}
+
+// ClusterMyID returns the cluster node id of the connected node
+func (c *Client) ClusterMyID() (string, error) {
+	return String(c.Do("CLUSTER", "MYID"))
+}
+
+// ClusterNodes returns the cluster topology as seen by the connected node
+func (c *Client) ClusterNodes() ([]ClusterNode, error) {
+	s, err := String(c.Do("CLUSTER", "NODES"))
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseClusterNodes(s)
+}
+
+// ClusterInfo returns the fields reported by CLUSTER INFO
+func (c *Client) ClusterInfo() (map[string]string, error) {
+	s, err := String(c.Do("CLUSTER", "INFO"))
+	if err != nil {
+		return nil, err
+	}
+
+	info := map[string]string{}
+	for _, line := range strings.Split(s, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(kv) == 2 {
+			info[kv[0]] = kv[1]
+		}
+	}
+
+	return info, nil
+}
+
+// ClusterMeet introduces the node at ip:port to the connected node's cluster
+func (c *Client) ClusterMeet(ip string, port int) error {
+	return OK(c.Do("CLUSTER", "MEET", ip, strconv.Itoa(port)))
+}
+
+// ClusterForget removes the node with the given id from the connected node's node table
+func (c *Client) ClusterForget(nodeID string) error {
+	return OK(c.Do("CLUSTER", "FORGET", nodeID))
+}
+
+// ClusterAddSlots assigns the given slots to the connected node
+func (c *Client) ClusterAddSlots(slots ...int) error {
+	args := make([]string, 0, len(slots)+2)
+	args = append(args, "CLUSTER", "ADDSLOTS")
+	for _, slot := range slots {
+		args = append(args, strconv.Itoa(slot))
+	}
+
+	return OK(c.Do(args...))
+}
+
+// ClusterSetSlotImporting marks a slot as being imported from the given node
+func (c *Client) ClusterSetSlotImporting(slot int, sourceID string) error {
+	return OK(c.Do("CLUSTER", "SETSLOT", strconv.Itoa(slot), "IMPORTING", sourceID))
+}
+
+// ClusterSetSlotMigrating marks a slot as being migrated to the given node
+func (c *Client) ClusterSetSlotMigrating(slot int, destinationID string) error {
+	return OK(c.Do("CLUSTER", "SETSLOT", strconv.Itoa(slot), "MIGRATING", destinationID))
+}
+
+// ClusterSetSlotNode assigns a slot to the given node, closing any migration
+func (c *Client) ClusterSetSlotNode(slot int, nodeID string) error {
+	return OK(c.Do("CLUSTER", "SETSLOT", strconv.Itoa(slot), "NODE", nodeID))
+}
+
+// ClusterSetSlotStable clears the migrating or importing state of a slot
+func (c *Client) ClusterSetSlotStable(slot int) error {
+	return OK(c.Do("CLUSTER", "SETSLOT", strconv.Itoa(slot), "STABLE"))
+}
+
+// ClusterCountKeysInSlot returns the number of keys stored in the given slot
+func (c *Client) ClusterCountKeysInSlot(slot int) (int, error) {
+	return Int(c.Do("CLUSTER", "COUNTKEYSINSLOT", strconv.Itoa(slot)))
+}
+
+// ClusterGetKeysInSlot returns up to count keys stored in the given slot
+func (c *Client) ClusterGetKeysInSlot(slot, count int) ([]string, error) {
+	return Strings(c.Do("CLUSTER", "GETKEYSINSLOT", strconv.Itoa(slot), strconv.Itoa(count)))
+}
+
+// Migrate atomically moves the given keys to the node at ip:port, authenticating with the
+// given password unless it's ""
+func (c *Client) Migrate(ip string, port int, password string, timeout time.Duration, keys ...string) error {
+	args := make([]string, 0, len(keys)+9)
+	args = append(args, "MIGRATE", ip, strconv.Itoa(port), "", "0", strconv.FormatInt(int64(timeout/time.Millisecond), 10))
+	if password != "" {
+		args = append(args, "AUTH", password)
+	}
+	args = append(args, "KEYS")
+	args = append(args, keys...)
+
+	return OK(c.Do(args...))
+}
+
+// ClusterReplicate makes the connected node a replica of the master with the given id
+func (c *Client) ClusterReplicate(masterID string) error {
+	return OK(c.Do("CLUSTER", "REPLICATE", masterID))
+}
+
+// ClusterReset resets the connected node's cluster state, forgetting every other node. A
+// replica becomes an empty master. A hard reset also gives the node a new id.
+func (c *Client) ClusterReset(hard bool) error {
+	mode := "SOFT"
+	if hard {
+		mode = "HARD"
+	}
+
+	return OK(c.Do("CLUSTER", "RESET", mode))
+}
+
+// ClusterFailover promotes the connected replica to master of its master's slots. The
+// option may be "" for a coordinated failover, "FORCE" to skip the master's agreement or
+// "TAKEOVER" to also skip the agreement of the rest of the cluster.
+func (c *Client) ClusterFailover(option string) error {
+	if option == "" {
+		return OK(c.Do("CLUSTER", "FAILOVER"))
+	}
+
+	return OK(c.Do("CLUSTER", "FAILOVER", option))
+}
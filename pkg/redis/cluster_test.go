@@ -0,0 +1,49 @@
+// +build unit
+
+package redis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClusterNodes(t *testing.T) {
+	t.Run("stable cluster", func(t *testing.T) {
+		nodes, err := ParseClusterNodes(`07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:30004@31004 slave e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 0 1426238317239 4 connected
+67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 127.0.0.1:30002@31002 master - 0 1426238316232 2 connected 5461-10922
+e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30001@31001 myself,master - 0 0 1 connected 0-5460 16383
+`)
+		require.NoError(t, err)
+		require.Len(t, nodes, 3)
+
+		require.Equal(t, "07c37dfeb235213a872192d90877d0cd55635b91", nodes[0].ID)
+		require.Equal(t, "127.0.0.1", nodes[0].IP)
+		require.Equal(t, 30004, nodes[0].Port)
+		require.True(t, nodes[0].HasFlag("slave"))
+		require.Equal(t, "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca", nodes[0].MasterID)
+		require.Equal(t, 0, nodes[0].SlotCount())
+
+		require.Equal(t, "", nodes[1].MasterID)
+		require.Equal(t, []SlotRange{{Start: 5461, End: 10922}}, nodes[1].Slots)
+
+		require.True(t, nodes[2].HasFlag("myself"))
+		require.True(t, nodes[2].HasFlag("master"))
+		require.Equal(t, 5462, nodes[2].SlotCount())
+		require.Equal(t, 16383, nodes[2].SlotList()[5461])
+	})
+
+	t.Run("open slots", func(t *testing.T) {
+		nodes, err := ParseClusterNodes(`e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30001@31001 myself,master - 0 0 1 connected 0-5460 [93->-292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f] [77-<-67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1]`)
+		require.NoError(t, err)
+		require.Len(t, nodes, 1)
+		require.Equal(t, map[int]string{93: "292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f"}, nodes[0].Migrating)
+		require.Equal(t, map[int]string{77: "67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1"}, nodes[0].Importing)
+		require.Equal(t, 5461, nodes[0].SlotCount())
+	})
+
+	t.Run("malformed line", func(t *testing.T) {
+		_, err := ParseClusterNodes("e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30001@31001")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,45 @@
+package redis
+
+import (
+	"strings"
+)
+
+// Auth authenticates the connection as the default user
+func (c *Client) Auth(password string) error {
+	if err := OK(c.Do("AUTH", password)); err != nil {
+		return err
+	}
+
+	c.password = password
+	return nil
+}
+
+// IsNoPasswordSet reports whether an error was returned by AUTH because the node doesn't
+// require a password
+func IsNoPasswordSet(err error) bool {
+	if _, ok := err.(Error); !ok {
+		return false
+	}
+
+	// redis 6 and redis 5 respectively
+	return strings.Contains(err.Error(), "without any password configured") || strings.Contains(err.Error(), "no password is set")
+}
+
+// ConfigSet changes a configuration parameter of the connected node at runtime
+func (c *Client) ConfigSet(parameter, value string) error {
+	return OK(c.Do("CONFIG", "SET", parameter, value))
+}
+
+// ACLSetUser creates or modifies an ACL user by applying the given rules in order
+func (c *Client) ACLSetUser(username string, rules ...string) error {
+	args := make([]string, 0, len(rules)+3)
+	args = append(args, "ACL", "SETUSER", username)
+	args = append(args, rules...)
+
+	return OK(c.Do(args...))
+}
+
+// ACLDelUser deletes an ACL user, disconnecting any clients authenticated as it
+func (c *Client) ACLDelUser(username string) error {
+	return OK(c.Do("ACL", "DELUSER", username))
+}